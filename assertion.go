@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+)
+
+// expectation specifies what the result of a custom query must look like.
+// All of the set conditions must hold for the result to be accepted.
+type expectation struct {
+	// ResultType is the expected type of the result, i.e. scalar, vector, matrix or string.
+	ResultType string `yaml:"result_type"`
+	// Series, MinSeries and MaxSeries bound the number of returned series.
+	Series    *int `yaml:"series"`
+	MinSeries *int `yaml:"min_series"`
+	MaxSeries *int `yaml:"max_series"`
+	// MinValue and MaxValue bound all returned sample values.
+	MinValue *float64 `yaml:"min_value"`
+	MaxValue *float64 `yaml:"max_value"`
+	// Labels must be set on every returned series. An empty value only requires the label to be present.
+	Labels map[string]string `yaml:"labels"`
+	// Empty requires the result to be empty if true and to not be empty if false.
+	Empty *bool `yaml:"empty"`
+}
+
+// assertionError is returned when the result of a query does not meet the expectation.
+type assertionError struct {
+	error
+}
+
+func (e assertionError) Unwrap() error {
+	return e.error
+}
+
+func (e expectation) validate() error {
+	switch e.ResultType {
+	case "", model.ValScalar.String(), model.ValVector.String(), model.ValMatrix.String(), model.ValString.String():
+	default:
+		return errors.Errorf("result_type is invalid: unknown type %q", e.ResultType)
+	}
+
+	for name, n := range map[string]*int{"series": e.Series, "min_series": e.MinSeries, "max_series": e.MaxSeries} {
+		if n != nil && *n < 0 {
+			return errors.Errorf("%s is invalid: %d is negative", name, *n)
+		}
+	}
+
+	if e.Series != nil && (e.MinSeries != nil || e.MaxSeries != nil) {
+		return errors.New("series cannot be combined with min_series or max_series")
+	}
+
+	if e.MinSeries != nil && e.MaxSeries != nil && *e.MinSeries > *e.MaxSeries {
+		return errors.New("min_series cannot be greater than max_series")
+	}
+
+	if e.MinValue != nil && e.MaxValue != nil && *e.MinValue > *e.MaxValue {
+		return errors.New("min_value cannot be greater than max_value")
+	}
+
+	for name := range e.Labels {
+		if !model.LabelName(name).IsValid() {
+			return errors.Errorf("labels is invalid: unsupported label name %q", name)
+		}
+	}
+
+	return nil
+}
+
+// check returns an assertionError if the result does not meet the expectation.
+func (e expectation) check(v model.Value) error {
+	if err := e.checkType(v); err != nil {
+		return assertionError{err}
+	}
+
+	series, samples := seriesOf(v)
+
+	if err := e.checkSeries(len(series)); err != nil {
+		return assertionError{err}
+	}
+
+	// NaN values are outside of any bounds.
+	for _, s := range samples {
+		if e.MinValue != nil && !(s >= *e.MinValue) {
+			return assertionError{errors.Errorf("expected values of at least %v, got %v", *e.MinValue, s)}
+		}
+
+		if e.MaxValue != nil && !(s <= *e.MaxValue) {
+			return assertionError{errors.Errorf("expected values of at most %v, got %v", *e.MaxValue, s)}
+		}
+	}
+
+	for _, m := range series {
+		if err := e.checkLabels(m); err != nil {
+			return assertionError{err}
+		}
+	}
+
+	return nil
+}
+
+func (e expectation) checkType(v model.Value) error {
+	if e.ResultType != "" && v.Type().String() != e.ResultType {
+		return errors.Errorf("expected result of type %s, got %s", e.ResultType, v.Type())
+	}
+
+	return nil
+}
+
+func (e expectation) checkSeries(n int) error {
+	switch {
+	case e.Empty != nil && *e.Empty && n != 0:
+		return errors.Errorf("expected empty result, got %d series", n)
+	case e.Empty != nil && !*e.Empty && n == 0:
+		return errors.New("expected non-empty result, got no series")
+	case e.Series != nil && n != *e.Series:
+		return errors.Errorf("expected %d series, got %d", *e.Series, n)
+	case e.MinSeries != nil && n < *e.MinSeries:
+		return errors.Errorf("expected at least %d series, got %d", *e.MinSeries, n)
+	case e.MaxSeries != nil && n > *e.MaxSeries:
+		return errors.Errorf("expected at most %d series, got %d", *e.MaxSeries, n)
+	}
+
+	return nil
+}
+
+func (e expectation) checkLabels(m model.Metric) error {
+	var missing []string
+
+	for name, val := range e.Labels {
+		got, ok := m[model.LabelName(name)]
+		switch {
+		case val == "" && !ok:
+			missing = append(missing, name)
+		case val != "" && string(got) != val:
+			missing = append(missing, fmt.Sprintf("%s=%q", name, val))
+		}
+	}
+
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return errors.Errorf("expected labels %s on series %s", strings.Join(missing, ", "), m)
+	}
+
+	return nil
+}
+
+// seriesOf returns the label sets of the series and the sample values in the result.
+// Scalars and strings count as a single series without labels.
+func seriesOf(v model.Value) ([]model.Metric, []float64) {
+	var (
+		series  []model.Metric
+		samples []float64
+	)
+
+	switch v := v.(type) {
+	case *model.Scalar:
+		series = append(series, model.Metric{})
+		samples = append(samples, float64(v.Value))
+	case *model.String:
+		series = append(series, model.Metric{})
+	case model.Vector:
+		for _, s := range v {
+			series = append(series, s.Metric)
+			samples = append(samples, float64(s.Value))
+		}
+	case model.Matrix:
+		for _, ss := range v {
+			series = append(series, ss.Metric)
+
+			for _, p := range ss.Values {
+				samples = append(samples, float64(p.Value))
+			}
+		}
+	}
+
+	return series, samples
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+)
+
+// histogramCount returns the number of observations of the histogram.
+func histogramCount(t *testing.T, o prometheus.Observer) int {
+	t.Helper()
+
+	m := &dto.Metric{}
+	if err := o.(prometheus.Metric).Write(m); err != nil {
+		t.Fatal(err)
+	}
+
+	return int(m.GetHistogram().GetSampleCount())
+}
+
+func TestExpectationCheck(t *testing.T) {
+	var (
+		zero, one, two = 0, 1, 2
+		low, high      = 1.0, 10.0
+		yes, no        = true, false
+	)
+
+	vector := func(values ...float64) model.Vector {
+		vec := make(model.Vector, len(values))
+		for i, v := range values {
+			vec[i] = &model.Sample{
+				Metric: model.Metric{"__name__": "up", "job": "up", "instance": model.LabelValue(string(rune('a' + i)))},
+				Value:  model.SampleValue(v),
+			}
+		}
+
+		return vec
+	}
+
+	for _, tc := range []struct {
+		name    string
+		expect  expectation
+		result  model.Value
+		invalid bool
+	}{
+		{name: "no conditions", result: vector()},
+		{name: "result type", expect: expectation{ResultType: "vector"}, result: vector(1)},
+		{name: "wrong result type", expect: expectation{ResultType: "matrix"}, result: vector(1), invalid: true},
+		{name: "series", expect: expectation{Series: &two}, result: vector(1, 2)},
+		{name: "too few series", expect: expectation{Series: &two}, result: vector(1), invalid: true},
+		{name: "too many series", expect: expectation{Series: &one}, result: vector(1, 2), invalid: true},
+		{name: "min series", expect: expectation{MinSeries: &one}, result: vector(1, 2)},
+		{name: "below min series", expect: expectation{MinSeries: &two}, result: vector(1), invalid: true},
+		{name: "max series", expect: expectation{MaxSeries: &two}, result: vector(1, 2)},
+		{name: "above max series", expect: expectation{MaxSeries: &one}, result: vector(1, 2), invalid: true},
+		{name: "zero series", expect: expectation{Series: &zero}, result: vector()},
+		{name: "values within bounds", expect: expectation{MinValue: &low, MaxValue: &high}, result: vector(1, 10)},
+		{name: "value below min", expect: expectation{MinValue: &low}, result: vector(1, 0.5), invalid: true},
+		{name: "value above max", expect: expectation{MaxValue: &high}, result: vector(10.5), invalid: true},
+		{name: "NaN value", expect: expectation{MinValue: &low}, result: vector(math.NaN()), invalid: true},
+		{name: "scalar within bounds", expect: expectation{MinValue: &low}, result: &model.Scalar{Value: 2}},
+		{
+			name:    "matrix value out of bounds",
+			expect:  expectation{MaxValue: &high},
+			result:  model.Matrix{{Metric: model.Metric{"job": "up"}, Values: []model.SamplePair{{Value: 1}, {Value: 11}}}},
+			invalid: true,
+		},
+		{name: "labels present", expect: expectation{Labels: map[string]string{"job": "up", "instance": ""}}, result: vector(1, 2)},
+		{name: "label missing", expect: expectation{Labels: map[string]string{"pod": ""}}, result: vector(1), invalid: true},
+		{name: "label value differs", expect: expectation{Labels: map[string]string{"job": "down"}}, result: vector(1), invalid: true},
+		{name: "empty", expect: expectation{Empty: &yes}, result: vector()},
+		{name: "not empty", expect: expectation{Empty: &yes}, result: vector(1), invalid: true},
+		{name: "non-empty", expect: expectation{Empty: &no}, result: vector(1)},
+		{name: "empty but expected non-empty", expect: expectation{Empty: &no}, result: vector(), invalid: true},
+		{name: "string counts as a series", expect: expectation{Empty: &no}, result: &model.String{Value: "up"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.expect.check(tc.result)
+			if !tc.invalid {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+
+				return
+			}
+
+			if _, ok := err.(assertionError); !ok {
+				t.Errorf("expected an assertion error, got %v", err)
+			}
+		})
+	}
+}
+
+func TestRunCustomQueryOutcomes(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("query") == "error" {
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"status":"success","data":{"resultType":"vector","result":[{"metric":{"job":"up"},"value":[1,"1"]}]}}`))
+	}))
+	defer srv.Close()
+
+	endpoint, err := url.Parse(srv.URL + "/api/v1/query")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		one, two = 1, 2
+		tn       = tenant{Name: "a", ReadEndpoint: endpoint, ReadTransport: http.DefaultTransport}
+	)
+
+	for _, tc := range []struct {
+		name             string
+		query            querySpec
+		errors, failures float64
+		outcome          string
+	}{
+		{
+			name:    "success",
+			query:   querySpec{Name: "success", Query: "up", Expect: &expectation{Series: &one}},
+			outcome: outcomeSuccess,
+		},
+		{
+			name:     "assertion failure",
+			query:    querySpec{Name: "assertion", Query: "up", Expect: &expectation{Series: &two}},
+			failures: 1,
+			outcome:  outcomeAssertionFailure,
+		},
+		{
+			name:    "error",
+			query:   querySpec{Name: "error", Query: "error", Expect: &expectation{Series: &one}},
+			errors:  1,
+			outcome: outcomeError,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := registerMetrics(prometheus.NewRegistry())
+
+			runCustomQuery(context.Background(), log.NewNopLogger(), tn, tc.query, m)
+
+			if v := counterValue(m.customQueryExecuted.WithLabelValues(tn.Name, tc.query.Name)); v != 1 {
+				t.Errorf("expected 1 run, got %v", v)
+			}
+
+			if v := counterValue(m.customQueryErrors.WithLabelValues(tn.Name, tc.query.Name)); v != tc.errors {
+				t.Errorf("expected %v errors, got %v", tc.errors, v)
+			}
+
+			if v := counterValue(m.customQueryAssertionFailures.WithLabelValues(tn.Name, tc.query.Name)); v != tc.failures {
+				t.Errorf("expected %v assertion failures, got %v", tc.failures, v)
+			}
+
+			for _, outcome := range []string{outcomeSuccess, outcomeError, outcomeAssertionFailure} {
+				expected := 0
+				if outcome == tc.outcome {
+					expected = 1
+				}
+
+				if n := histogramCount(t, m.customQueryDuration.WithLabelValues(tn.Name, tc.query.Name, outcome)); n != expected {
+					t.Errorf("expected %d runs with outcome %s, got %d", expected, outcome, n)
+				}
+			}
+		})
+	}
+}
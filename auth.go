@@ -0,0 +1,246 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type headerArg http.Header
+
+func (ha *headerArg) String() string {
+	hs := make([]string, 0, len(*ha))
+	for name, vs := range *ha {
+		for _, v := range vs {
+			hs = append(hs, name+": "+v)
+		}
+	}
+
+	return strings.Join(hs, ", ")
+}
+
+func (ha *headerArg) Set(v string) error {
+	parts := strings.SplitN(v, ":", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return errors.Errorf("unrecognized header %q, expected 'Name: value'", v)
+	}
+
+	if *ha == nil {
+		*ha = headerArg{}
+	}
+
+	http.Header(*ha).Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+
+	return nil
+}
+
+// UnmarshalYAML reads headers from a mapping of header names to values.
+func (ha *headerArg) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var hs map[string]string
+	if err := unmarshal(&hs); err != nil {
+		return err
+	}
+
+	*ha = headerArg{}
+	for name, v := range hs {
+		http.Header(*ha).Set(name, v)
+	}
+
+	return nil
+}
+
+// authConfig configures how requests to an endpoint are authenticated.
+// Requests either carry a bearer token or basic auth credentials, plus any static headers.
+type authConfig struct {
+	Token    TokenProvider
+	Username string
+	Password TokenProvider
+	Headers  http.Header
+}
+
+// collectors returns the metrics of the configured credential providers.
+func (a authConfig) collectors() []prometheus.Collector {
+	var cs []prometheus.Collector
+
+	for _, p := range []TokenProvider{a.Token, a.Password} {
+		if c, ok := p.(prometheus.Collector); ok {
+			cs = append(cs, c)
+		}
+	}
+
+	return cs
+}
+
+// authError is returned when the credentials for a request cannot be retrieved.
+type authError struct {
+	error
+}
+
+func (e authError) Unwrap() error {
+	return e.error
+}
+
+type authRoundTripper struct {
+	auth authConfig
+	next http.RoundTripper
+}
+
+// newAuthRoundTripper returns a round tripper authenticating all requests according to the configuration.
+func newAuthRoundTripper(auth authConfig, next http.RoundTripper) http.RoundTripper {
+	return &authRoundTripper{auth: auth, next: next}
+}
+
+func (r *authRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	// A RoundTripper must not modify the given request.
+	req = req.Clone(req.Context())
+
+	for name, vs := range r.auth.Headers {
+		req.Header[name] = vs
+	}
+
+	if r.auth.Username != "" {
+		password, err := r.auth.Password.Get()
+		if err != nil {
+			return nil, authError{errors.Wrap(err, "retrieving password")}
+		}
+
+		req.SetBasicAuth(r.auth.Username, password)
+
+		return r.next.RoundTrip(req)
+	}
+
+	token, err := r.auth.Token.Get()
+	if err != nil {
+		return nil, authError{errors.Wrap(err, "retrieving token")}
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	return r.next.RoundTrip(req)
+}
+
+// authSpec specifies the credentials for an endpoint, either from flags or from a file.
+type authSpec struct {
+	Token        string     `yaml:"token"`
+	TokenFile    string     `yaml:"token_file"`
+	OIDC         oidcConfig `yaml:"oidc"`
+	Username     string     `yaml:"basic_auth_username"`
+	Password     string     `yaml:"basic_auth_password"`
+	PasswordFile string     `yaml:"basic_auth_password_file"`
+	Headers      headerArg  `yaml:"headers"`
+}
+
+// register registers the authentication flags, prefixing their names with the given prefix.
+// If overrides is set, the help texts note that the flags override the unprefixed ones.
+func (s *authSpec) register(fs *flag.FlagSet, prefix, target string, overrides bool) {
+	override := func(name string) string {
+		if !overrides {
+			return ""
+		}
+
+		return fmt.Sprintf(" Overrides --%s.", name)
+	}
+
+	fs.StringVar(&s.Token, prefix+"token", "",
+		fmt.Sprintf("The bearer token to set in the authorization header on requests to %s. Takes precedence over --%stoken-file if set.%s",
+			target, prefix, override("token")))
+	fs.StringVar(&s.TokenFile, prefix+"token-file", "",
+		fmt.Sprintf("The file to read a bearer token from and set in the authorization header on requests to %s. "+
+			"The file is read again whenever it changes.%s", target, override("token-file")))
+	fs.StringVar(&s.Username, prefix+"basic-auth-username", "",
+		fmt.Sprintf("The username for HTTP basic authentication on requests to %s. Cannot be combined with a bearer token.%s",
+			target, override("basic-auth-username")))
+	fs.StringVar(&s.Password, prefix+"basic-auth-password", "",
+		fmt.Sprintf("The password for HTTP basic authentication on requests to %s. Takes precedence over --%sbasic-auth-password-file if set.",
+			target, prefix))
+	fs.StringVar(&s.PasswordFile, prefix+"basic-auth-password-file", "",
+		fmt.Sprintf("The file to read the password for HTTP basic authentication on requests to %s from.", target))
+	fs.Var(&s.Headers, prefix+"header",
+		fmt.Sprintf("A header to set on requests to %s, in the form 'Name: value'. Can be repeated.%s", target, override("header")))
+}
+
+func (s authSpec) bearer() bool {
+	return s.Token != "" || s.TokenFile != "" || s.OIDC.enabled()
+}
+
+// inherit returns the spec with the credentials of the base spec if it sets none of its own.
+// Headers are merged, the ones of the spec taking precedence.
+func (s authSpec) inherit(base authSpec) authSpec {
+	if !s.bearer() && s.Username == "" {
+		s.Token, s.TokenFile, s.OIDC = base.Token, base.TokenFile, base.OIDC
+		s.Username, s.Password, s.PasswordFile = base.Username, base.Password, base.PasswordFile
+	}
+
+	headers := headerArg{}
+	for name, vs := range base.Headers {
+		headers[name] = vs
+	}
+
+	for name, vs := range s.Headers {
+		headers[name] = vs
+	}
+
+	s.Headers = headers
+
+	return s
+}
+
+func (s authSpec) validate() error {
+	if s.bearer() && s.Username != "" {
+		return errors.New("basic auth cannot be combined with a bearer token")
+	}
+
+	if s.Username == "" && (s.Password != "" || s.PasswordFile != "") {
+		return errors.New("basic auth password requires a username")
+	}
+
+	if s.OIDC.enabled() && s.OIDC.ClientID == "" {
+		return errors.New("OIDC client ID is required to fetch tokens from an OIDC issuer")
+	}
+
+	return nil
+}
+
+// build creates the authentication configuration.
+// Credentials set in the spec replace the ones of the base configuration, headers are merged.
+// OIDC tokens are fetched with the given issuer transport.
+func (s authSpec) build(l log.Logger, base authConfig, issuer http.RoundTripper) authConfig {
+	auth := authConfig{
+		Token:    base.Token,
+		Username: base.Username,
+		Password: base.Password,
+		Headers:  http.Header{},
+	}
+
+	if auth.Token == nil {
+		auth.Token = NewNoOpTokenProvider()
+	}
+
+	for name, vs := range base.Headers {
+		auth.Headers[name] = vs
+	}
+
+	for name, vs := range s.Headers {
+		auth.Headers[name] = vs
+	}
+
+	if s.bearer() {
+		auth.Token = tokenProvider(l, s.Token, s.TokenFile, s.OIDC, issuer)
+		auth.Username, auth.Password = "", nil
+	}
+
+	if s.Username != "" {
+		auth.Token = NewNoOpTokenProvider()
+		auth.Username = s.Username
+		auth.Password = tokenProvider(l, s.Password, s.PasswordFile, oidcConfig{}, issuer)
+	}
+
+	return auth
+}
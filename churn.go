@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+	promapi "github.com/prometheus/client_golang/api"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// churnTolerance is how long after a rotation samples of the rotated series are still accepted.
+// The label sets of a request are rendered just before its samples, so a request can straddle a rotation.
+const churnTolerance = time.Second
+
+// churnConfig configures the rotation of a label of a fraction of the written series, like pods replaced by a rollout.
+type churnConfig struct {
+	Label    string        `yaml:"label"`
+	Fraction float64       `yaml:"fraction"`
+	Interval time.Duration `yaml:"interval"`
+}
+
+// register registers the flags configuring churn.
+func (c *churnConfig) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.Label, "churn-label", "pod", "The label of --labels whose value is rotated under churn.")
+	fs.Float64Var(&c.Fraction, "churn-fraction", 0,
+		"The fraction of the series whose --churn-label value is rotated every --churn-interval, e.g. 0.2 for 20%. 0 disables churn. "+
+			"The reader checks that the rotated series stop receiving samples and that their replacements appear.")
+	fs.DurationVar(&c.Interval, "churn-interval", 5*time.Minute,
+		"The interval at which the series are rotated under churn. Must be greater than --latency.")
+}
+
+// rotated returns the number of series whose label is rotated, which are the first series of the workload.
+func (c churnConfig) rotated(series int) int {
+	return int(math.Ceil(c.Fraction * float64(series)))
+}
+
+// generation returns the number of rotations up to the given time.
+// Rotations are aligned to the wall clock, so writers and readers agree on them, also across restarts.
+func (c churnConfig) generation(ts time.Time) int64 {
+	return ts.UnixNano() / int64(c.Interval)
+}
+
+// rotatedAt returns the time of the rotation that started the given generation.
+func (c churnConfig) rotatedAt(generation int64) time.Time {
+	return time.Unix(0, generation*int64(c.Interval))
+}
+
+// rotate suffixes the value of the rotated label with the generation.
+func (c churnConfig) rotate(lset []prompb.Label, generation int64) {
+	for i := range lset {
+		if lset[i].Name == c.Label {
+			lset[i].Value = fmt.Sprintf("%s-%d", lset[i].Value, generation)
+		}
+	}
+}
+
+// readChurned checks that the series rotated away at the start of the given generation received no samples after the rotation.
+// Without staleness markers in remote-write, they are still returned by instant queries within the lookback period.
+func readChurned(ctx context.Context, client promapi.Client, endpoint *url.URL, w *workload, generation int64, ts time.Time) error {
+	sets, err := w.labelSetsAt(generation - 1)
+	if err != nil {
+		return errors.Wrap(err, "generate series")
+	}
+
+	sets = sets[:w.churn.rotated(w.series)]
+
+	vec, err := instantQuery(ctx, client, endpoint, selector(sets), ts)
+	if err != nil {
+		return err
+	}
+
+	var (
+		rotatedAt = w.churn.rotatedAt(generation)
+		limit     = float64(rotatedAt.Add(churnTolerance).UnixNano() / int64(time.Millisecond))
+		written   int
+	)
+
+	for _, s := range vec {
+		if float64(s.Value) > limit {
+			written++
+		}
+	}
+
+	if written > 0 {
+		return fmt.Errorf("%d of %d rotated series received samples after their rotation at %s",
+			written, len(sets), rotatedAt.UTC().Format(time.RFC3339))
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// compressionSnappy compresses remote-write requests with block snappy, as required by the remote-write specification.
+	compressionSnappy = "snappy"
+	// compressionZstd compresses remote-write requests with zstd.
+	compressionZstd = "zstd"
+	// compressionGzip compresses remote-write requests with gzip.
+	compressionGzip = "gzip"
+	// compressionNone sends remote-write requests uncompressed.
+	compressionNone = "none"
+)
+
+// zstdEncoder is shared by all writers, as encoders are expensive to create and safe to use concurrently for whole buffers.
+// Creating an encoder without options cannot fail.
+var zstdEncoder, _ = zstd.NewWriter(nil)
+
+func validCompression(compression string) bool {
+	switch compression {
+	case compressionSnappy, compressionZstd, compressionGzip, compressionNone:
+		return true
+	default:
+		return false
+	}
+}
+
+// compressor compresses remote-write requests and observes their sizes before and after compression.
+type compressor struct {
+	compression  string
+	uncompressed prometheus.Observer
+	compressed   prometheus.Observer
+}
+
+func newCompressor(compression, tenant string, m metrics) compressor {
+	return compressor{
+		compression:  compression,
+		uncompressed: m.remoteWriteUncompressedBytes.WithLabelValues(tenant, compression),
+		compressed:   m.remoteWriteCompressedBytes.WithLabelValues(tenant, compression),
+	}
+}
+
+// compress compresses the body and returns it along with the value of its Content-Encoding header,
+// which is empty for uncompressed bodies.
+func (c compressor) compress(body []byte) ([]byte, string, error) {
+	b, encoding, err := compress(c.compression, body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	c.uncompressed.Observe(float64(len(body)))
+	c.compressed.Observe(float64(len(b)))
+
+	return b, encoding, nil
+}
+
+func compress(compression string, body []byte) ([]byte, string, error) {
+	switch compression {
+	case compressionZstd:
+		return zstdEncoder.EncodeAll(body, nil), compressionZstd, nil
+	case compressionGzip:
+		var buf bytes.Buffer
+
+		w := gzip.NewWriter(&buf)
+		if _, err := w.Write(body); err != nil {
+			return nil, "", errors.Wrap(err, "gzip")
+		}
+
+		if err := w.Close(); err != nil {
+			return nil, "", errors.Wrap(err, "gzip")
+		}
+
+		return buf.Bytes(), compressionGzip, nil
+	case compressionNone:
+		return body, "", nil
+	default:
+		return snappy.Encode(nil, body), compressionSnappy, nil
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// config holds all options as set by flags and the config file.
+// The fields of the config file mirror the flags, with sections for authentication, TLS, retries, metadata, churn and the load mode.
+type config struct {
+	LogLevel          string         `yaml:"log_level"`
+	EndpointType      string         `yaml:"endpoint_type"`
+	LogsPushFormat    string         `yaml:"logs_push_format"`
+	WriteEndpoint     string         `yaml:"endpoint_write"`
+	ReadEndpoint      string         `yaml:"endpoint_read"`
+	WriteProtocol     string         `yaml:"endpoint_write_protocol"`
+	ReadProtocol      string         `yaml:"endpoint_read_protocol"`
+	Tenant            string         `yaml:"tenant"`
+	TenantHeader      string         `yaml:"tenant_header"`
+	TenantsFile       string         `yaml:"tenants_file"`
+	Tenants           []tenantSpec   `yaml:"tenants"`
+	Auth              authSpec       `yaml:"auth"`
+	ReadAuth          authSpec       `yaml:"read_auth"`
+	TLS               tlsConfig      `yaml:"tls"`
+	Labels            labelArg       `yaml:"labels"`
+	Listen            string         `yaml:"listen"`
+	Name              string         `yaml:"name"`
+	Series            int            `yaml:"series"`
+	SeriesType        string         `yaml:"series_type"`
+	Metadata          metadataConfig `yaml:"metadata"`
+	Exemplars         bool           `yaml:"exemplars"`
+	QueriesFile       string         `yaml:"queries_file"`
+	Queries           []querySpec    `yaml:"queries"`
+	Period            time.Duration  `yaml:"period"`
+	WriteRetry        retryConfig    `yaml:"write_retry"`
+	WriteCompression  string         `yaml:"write_compression"`
+	Load              loadConfig     `yaml:"load"`
+	Churn             churnConfig    `yaml:"churn"`
+	Duration          time.Duration  `yaml:"duration"`
+	SuccessThreshold  float64        `yaml:"threshold"`
+	Latency           time.Duration  `yaml:"latency"`
+	InitialQueryDelay time.Duration  `yaml:"initial_query_delay"`
+	QueryConcurrency  int            `yaml:"query_concurrency"`
+	QueriesThreshold  float64        `yaml:"queries_threshold"`
+	ReloadInterval    time.Duration  `yaml:"reload_interval"`
+
+	// file is the config file the options were read from, if any.
+	file string
+	// flags are the names of the flags set on the command line.
+	flags map[string]bool
+}
+
+// registerFlags registers the flags setting the options of the config.
+func (c *config) registerFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.LogLevel, "log.level", "info", "The log filtering level. Options: 'error', 'warn', 'info', 'debug'.")
+	fs.StringVar(&c.EndpointType, "endpoint-type", endpointTypeMetrics,
+		"The type of the probed endpoints. Options: 'metrics', 'logs'. "+
+			"Logs are pushed to a Loki push endpoint and read back from a Loki query_range endpoint.")
+	fs.StringVar(&c.LogsPushFormat, "logs-push-format", logsPushFormatProtobuf,
+		"The format of requests to the Loki push endpoint. Options: 'protobuf', 'json'.")
+	fs.StringVar(&c.WriteEndpoint, "endpoint-write", "", "The endpoint to which to make remote-write requests.")
+	fs.StringVar(&c.ReadEndpoint, "endpoint-read", "", "The endpoint to which to make query requests.")
+	fs.StringVar(&c.WriteProtocol, "endpoint-write-protocol", writeProtocolRemoteWrite,
+		"The protocol used to write metrics to the write endpoint. Options: 'remote-write', 'remote-write-v2', 'otlp-protobuf', 'otlp-json'. "+
+			"With 'remote-write-v2' the written samples reported by the receiver are checked against the request. "+
+			"OTLP metrics are written to an OTLP/HTTP metrics endpoint, e.g. /api/v1/otlp/v1/metrics of Prometheus.")
+	fs.StringVar(&c.ReadProtocol, "endpoint-read-protocol", readProtocolQuery,
+		"The protocol used to read written metrics back from the read endpoint. Options: 'query', 'remote-read'.")
+	fs.StringVar(&c.Tenant, "tenant", "", "The name of the probed tenant. All metrics are labelled with it.")
+	fs.StringVar(&c.TenantHeader, "tenant-header", "",
+		"The header to set to the name of the tenant on all requests, e.g. 'THANOS-TENANT' or 'X-Scope-OrgID'.")
+	fs.StringVar(&c.TenantsFile, "tenants-file", "",
+		"A file listing tenants to probe, each with its own endpoints, credentials and threshold. "+
+			"Tenants use the authentication flags and --tenant-header unless they set their own. "+
+			"Cannot be combined with --endpoint-write, --endpoint-read and --tenant.")
+	fs.Var(&c.Labels, "labels", "The labels in addition to '__name__' that should be applied to remote-write requests.")
+	fs.StringVar(&c.Listen, "listen", ":8080", "The address on which internal server runs.")
+	fs.StringVar(&c.Name, "name", "up",
+		"The name of the metric to send in remote-write requests. With --endpoint-type=logs it is set as the name label of the pushed streams.")
+	fs.IntVar(&c.Series, "series", 1,
+		"The number of series to send in every remote-write request. "+
+			"Label values can be templated with the series index to distinguish them, e.g. 'instance=\"up-{{.Index}}\"'.")
+	fs.StringVar(&c.SeriesType, "series-type", seriesTypeGauge,
+		"The type of the written series. Options: 'gauge', 'histogram', 'summary', 'native-histogram'. "+
+			"Histograms and summaries hold fixed observations, whose quantiles and counts are checked by the reader, "+
+			"and their sum is the current timestamp in milliseconds.")
+	c.Metadata.register(fs)
+	fs.BoolVar(&c.Exemplars, "exemplars", false,
+		"Attach an exemplar with a random trace_id label to the written series and check it against the /api/v1/query_exemplars endpoint "+
+			"next to the read endpoint. Classic histograms carry it on the bucket of the median observation, summaries are not supported.")
+	c.Auth.register(fs, "", "the write and read endpoints", false)
+	c.ReadAuth.register(fs, "read-", "the read endpoint", true)
+	c.TLS.register(fs)
+	c.Auth.OIDC.register(fs)
+	fs.StringVar(&c.QueriesFile, "queries-file", "",
+		"A file containing queries to run against the read endpoint. Replaces the queries of the config file.")
+	fs.DurationVar(&c.Period, "period", 5*time.Second, "The time to wait between remote-write requests.")
+	c.WriteRetry.register(fs)
+	c.Load.register(fs)
+	c.Churn.register(fs)
+	fs.StringVar(&c.WriteCompression, "write-compression", compressionSnappy,
+		"The compression of remote-write requests. Options: 'snappy', 'zstd', 'gzip', 'none'. "+
+			"Only snappy is required by the remote-write specification, the others are meant for benchmarking receivers supporting them.")
+	fs.DurationVar(&c.Duration, "duration", 5*time.Minute,
+		"The duration of the up command to run until it stops. If 0 it will not stop until the process is terminated.")
+	fs.Float64Var(&c.SuccessThreshold, "threshold", 0.9, "The percentage of successful requests needed to succeed overall. 0 - 1.")
+	fs.DurationVar(&c.Latency, "latency", 15*time.Second, "The maximum allowable latency between writing and reading.")
+	fs.DurationVar(&c.InitialQueryDelay, "initial-query-delay", 5*time.Second, "The time to wait before executing the first query.")
+	fs.IntVar(&c.QueryConcurrency, "query-concurrency", 4,
+		"The maximum number of custom queries run at the same time for each tenant.")
+	fs.Float64Var(&c.QueriesThreshold, "queries-threshold", 0.9,
+		"The percentage of successful runs of all custom queries of a tenant needed to succeed overall. 0 - 1. "+
+			"If no query was executed, they fail unless it is 0. Thresholds of single queries and latency SLOs can be set in the queries file.")
+	fs.DurationVar(&c.ReloadInterval, "reload-interval", 0,
+		"The interval at which to check the config, queries and tenants files for changes and reload them. If 0 they are not checked. "+
+			"The configuration is reloaded on SIGHUP and on POST requests to /-/reload either way.")
+}
+
+// parseConfig parses the flags from the given arguments and reads the config file they point to, if any.
+func parseConfig(args []string) (config, error) {
+	var configFile string
+
+	cfg := config{}
+
+	fs := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	fs.StringVar(&configFile, "config-file", "",
+		"A YAML or JSON file setting any of the options, with fields named after the flags. Flags override the values of the file.")
+	cfg.registerFlags(fs)
+
+	// Read the config file before parsing the flags, so the ones set on the command line override its values.
+	// The flags are parsed only once, as repeatable flags like --header add to their values.
+	if file := configFileArg(args); file != "" {
+		if err := cfg.load(file); err != nil {
+			return cfg, fmt.Errorf("--config-file is invalid: %w", err)
+		}
+	}
+
+	if err := fs.Parse(args); err != nil {
+		return cfg, err
+	}
+
+	cfg.flags = map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		cfg.flags[f.Name] = true
+	})
+
+	return cfg, nil
+}
+
+// configFileArg returns the config file set by the arguments, if any.
+// Errors are left to the actual parsing of the flags.
+func configFileArg(args []string) string {
+	var (
+		file string
+		fs   = flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	)
+
+	fs.SetOutput(ioutil.Discard)
+	fs.StringVar(&file, "config-file", "", "")
+	// The other flags are registered as well, so their values are skipped.
+	(&config{}).registerFlags(fs)
+
+	_ = fs.Parse(args)
+
+	return file
+}
+
+// files returns the files the options were read from.
+func (c config) files() []string {
+	var fs []string
+
+	for _, f := range []string{c.file, c.QueriesFile, c.TenantsFile} {
+		if f != "" {
+			fs = append(fs, f)
+		}
+	}
+
+	return fs
+}
+
+// load reads the config file on top of the options already set.
+// Options missing from the file keep their values.
+func (c *config) load(file string) error {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	// JSON is valid YAML, so the config file can be written in either.
+	if err := yaml.UnmarshalStrict(b, c); err != nil {
+		return err
+	}
+
+	c.file = file
+
+	return nil
+}
+
+// option returns how to refer to an option in errors.
+// Options are referred to by their field in the config file, unless they were set on the command line.
+func (c config) option(flagName, field string) string {
+	if c.file == "" || c.flags[flagName] {
+		return "--" + flagName
+	}
+
+	return fmt.Sprintf("%s in %s", field, c.file)
+}
+
+// section returns how to refer to a group of options, e.g. the authentication flags, in errors.
+func (c config) section(flags, field string) string {
+	if c.file == "" {
+		return flags
+	}
+
+	return fmt.Sprintf("%s or %s in %s", flags, field, c.file)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "up")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(file, []byte("period: 20s\nseries: 3\nauth:\n  headers:\n    X-Source: file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	plain := filepath.Join(dir, "plain.yaml")
+	if err := ioutil.WriteFile(plain, []byte("period: 20s\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		args    []string
+		period  time.Duration
+		series  int
+		headers headerArg
+		flags   map[string]bool
+	}{
+		{
+			name:    "flags only",
+			args:    []string{"--period=30s", "--header=X-Foo: bar"},
+			period:  30 * time.Second,
+			series:  1,
+			headers: headerArg{"X-Foo": {"bar"}},
+			flags:   map[string]bool{"period": true, "header": true},
+		},
+		{
+			name:    "file only",
+			args:    []string{"--config-file=" + file},
+			period:  20 * time.Second,
+			series:  3,
+			headers: headerArg{"X-Source": {"file"}},
+			flags:   map[string]bool{"config-file": true},
+		},
+		{
+			name:    "flags override file",
+			args:    []string{"--config-file=" + file, "--period=30s", "--header=X-Foo: bar"},
+			period:  30 * time.Second,
+			series:  3,
+			headers: headerArg{"X-Source": {"file"}, "X-Foo": {"bar"}},
+			flags:   map[string]bool{"config-file": true, "period": true, "header": true},
+		},
+		{
+			name:    "repeatable flags over file",
+			args:    []string{"--config-file=" + plain, "--header=X-Foo: bar", "--header=X-Foo: baz"},
+			period:  20 * time.Second,
+			series:  1,
+			headers: headerArg{"X-Foo": {"bar", "baz"}},
+			flags:   map[string]bool{"config-file": true, "header": true},
+		},
+		{
+			name:    "file after flags",
+			args:    []string{"--period=30s", "--header=X-Foo: bar", "--config-file=" + file},
+			period:  30 * time.Second,
+			series:  3,
+			headers: headerArg{"X-Source": {"file"}, "X-Foo": {"bar"}},
+			flags:   map[string]bool{"config-file": true, "period": true, "header": true},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg, err := parseConfig(tc.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if cfg.Period != tc.period {
+				t.Errorf("expected period %s, got %s", tc.period, cfg.Period)
+			}
+
+			if cfg.Series != tc.series {
+				t.Errorf("expected %d series, got %d", tc.series, cfg.Series)
+			}
+
+			if !reflect.DeepEqual(cfg.Auth.Headers, tc.headers) {
+				t.Errorf("expected headers %v, got %v", tc.headers, cfg.Auth.Headers)
+			}
+
+			if !reflect.DeepEqual(cfg.flags, tc.flags) {
+				t.Errorf("expected flags %v to be set, got %v", tc.flags, cfg.flags)
+			}
+		})
+	}
+}
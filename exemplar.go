@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/rand"
+	"net/url"
+	"regexp"
+	"time"
+
+	"github.com/pkg/errors"
+	promapi "github.com/prometheus/client_golang/api"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// exemplarTraceIDLabel is the label of exemplars holding the trace ID.
+const exemplarTraceIDLabel = "trace_id"
+
+// traceIDPattern matches trace IDs as generated by newTraceID, the W3C trace context format.
+var traceIDPattern = regexp.MustCompile("^[0-9a-f]{32}$")
+
+// exemplar is an exemplar of a series, as sent in remote-write requests.
+type exemplar struct {
+	Labels    []prompb.Label
+	Value     float64
+	Timestamp int64
+}
+
+// fields returns the protobuf fields of the exemplar:
+//
+//	message Exemplar { repeated Label labels = 1; double value = 2; int64 timestamp = 3; }
+//	message Label { string name = 1; string value = 2; }
+func (e exemplar) fields() []protoField {
+	fs := make([]protoField, 0, len(e.Labels)+2)
+	for _, l := range e.Labels {
+		fs = append(fs, messageField(1, stringField(1, l.Name), stringField(2, l.Value)))
+	}
+
+	return append(fs, doubleField(2, e.Value), varintField(3, uint64(e.Timestamp)))
+}
+
+// newTraceID returns a random trace ID of 16 bytes in hex.
+// It only has to look like a trace ID to backends, so it does not need a secure source.
+func newTraceID() string {
+	b := make([]byte, 16)
+	rand.Read(b) //nolint:gosec
+
+	return hex.EncodeToString(b)
+}
+
+// exemplarSets returns the label sets of the series that carry exemplars for the series type:
+// gauges and native histograms themselves and the bucket of classic histograms the median observation falls into.
+// Summaries carry none, as exemplars are not defined for them.
+func exemplarSets(typ string, sets [][]prompb.Label) [][]prompb.Label {
+	switch typ {
+	case seriesTypeHistogram:
+		return mapSets(sets, func(lset []prompb.Label) []prompb.Label {
+			return withLabel(withName(lset, metricName(lset)+"_bucket"), "le", formatFloat(observationsMedian))
+		})
+	case seriesTypeSummary:
+		return nil
+	default:
+		return sets
+	}
+}
+
+// exemplarValue returns the value of the exemplars of the series type.
+// Gauges get an exemplar of their own value, which is the timestamp, and histograms of the median observation.
+func exemplarValue(typ string, timestamp int64) float64 {
+	if typ == seriesTypeGauge {
+		return float64(timestamp)
+	}
+
+	return observationsMedian
+}
+
+// attachExemplars attaches an exemplar with the trace ID to every series of the request that carries exemplars.
+func attachExemplars(wreq *writeRequest, typ string, sets [][]prompb.Label, traceID string, timestamp int64) {
+	carriers := map[string]struct{}{}
+	for _, lset := range exemplarSets(typ, sets) {
+		carriers[metricOf(lset).String()] = struct{}{}
+	}
+
+	for i, ts := range wreq.Timeseries {
+		if _, ok := carriers[metricOf(ts.Labels).String()]; !ok {
+			continue
+		}
+
+		wreq.Exemplars[i] = append(wreq.Exemplars[i], exemplar{
+			Labels:    []prompb.Label{{Name: exemplarTraceIDLabel, Value: traceID}},
+			Value:     exemplarValue(typ, timestamp),
+			Timestamp: timestamp,
+		})
+	}
+}
+
+// metricOf converts the label set to a metric, to compare label sets regardless of their order.
+func metricOf(lset []prompb.Label) model.Metric {
+	m := make(model.Metric, len(lset))
+	for _, l := range lset {
+		m[model.LabelName(l.Name)] = model.LabelValue(l.Value)
+	}
+
+	return m
+}
+
+// exemplarsResult is a series with its exemplars, as returned by the query_exemplars API.
+type exemplarsResult struct {
+	SeriesLabels model.Metric `json:"seriesLabels"`
+	Exemplars    []struct {
+		Labels    model.Metric      `json:"labels"`
+		Value     model.SampleValue `json:"value"`
+		Timestamp model.Time        `json:"timestamp"`
+	} `json:"exemplars"`
+}
+
+// readExemplars checks that the query_exemplars API returns recent exemplars with a trace ID for every series carrying them.
+func readExemplars(
+	ctx context.Context,
+	client promapi.Client,
+	endpoint *url.URL,
+	typ string,
+	sets [][]prompb.Label,
+	latency time.Duration,
+) error {
+	carriers := exemplarSets(typ, sets)
+	if len(carriers) == 0 {
+		return nil
+	}
+
+	u := apiEndpoint(endpoint, "query_exemplars")
+	end := time.Now()
+
+	q := u.Query()
+	q.Set("query", selector(carriers))
+	q.Set("start", formatTime(end.Add(-latency)))
+	q.Set("end", formatTime(end))
+
+	_, body, err := doGetFallback(ctx, client, u, q) //nolint:bodyclose
+	if err != nil {
+		return errors.Wrap(err, "exemplars request failed")
+	}
+
+	var result struct {
+		Status string            `json:"status"`
+		Data   []exemplarsResult `json:"data"`
+	}
+
+	if err := json.Unmarshal(body, &result); err != nil {
+		return errors.Wrap(err, "exemplars response parse failed")
+	}
+
+	// The newest exemplar of every series is checked like a sample, with its timestamp as value.
+	vec := make(model.Vector, 0, len(result.Data))
+
+	for _, r := range result.Data {
+		if len(r.Exemplars) == 0 {
+			continue
+		}
+
+		newest := r.Exemplars[0]
+		for _, e := range r.Exemplars[1:] {
+			if e.Timestamp.After(newest.Timestamp) {
+				newest = e
+			}
+		}
+
+		traceID := string(newest.Labels[exemplarTraceIDLabel])
+		if !traceIDPattern.MatchString(traceID) {
+			return fmt.Errorf("invalid trace ID %q in exemplar of %s", traceID, r.SeriesLabels)
+		}
+
+		if expected := exemplarValue(typ, int64(newest.Timestamp)); math.Abs(float64(newest.Value)-expected) > 1e-9 {
+			return fmt.Errorf("expected exemplar value %v, got %v for %s", expected, newest.Value, r.SeriesLabels)
+		}
+
+		vec = append(vec, &model.Sample{Metric: r.SeriesLabels, Value: model.SampleValue(newest.Timestamp), Timestamp: newest.Timestamp})
+	}
+
+	return errors.Wrap(verify(carriers, vec, latency, prometheus.ObserverFunc(func(float64) {})), "exemplars")
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	promapi "github.com/prometheus/client_golang/api"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+const (
+	// seriesTypeGauge writes a single float sample per series.
+	seriesTypeGauge = "gauge"
+	// seriesTypeHistogram writes a classic histogram per series, made of bucket, sum and count series.
+	seriesTypeHistogram = "histogram"
+	// seriesTypeSummary writes a summary per series, made of quantile, sum and count series.
+	seriesTypeSummary = "summary"
+	// seriesTypeNativeHistogram writes a native histogram sample per series.
+	seriesTypeNativeHistogram = "native-histogram"
+)
+
+// The histograms and summaries all hold the same four observations: 0.75, 1, 1.5 and 2.
+// Their sum is set to the current timestamp in milliseconds instead, like the value of gauges, so it can be checked for recency.
+const (
+	observationsCount = 4
+	// observationsMedian is the median of the observations, which is also the 0.5 quantile of the histograms,
+	// as it is the upper bound of the bucket the rank of the quantile falls into.
+	observationsMedian = 1
+)
+
+var (
+	// classicBuckets are the upper bounds and cumulative counts of the buckets of classic histograms.
+	classicBuckets = []struct {
+		le    float64
+		count float64
+	}{{0.5, 0}, {1, 2}, {2, 4}, {math.Inf(1), 4}}
+	// summaryQuantiles are the quantiles of summaries.
+	summaryQuantiles = []struct {
+		quantile float64
+		value    float64
+	}{{0.5, observationsMedian}, {0.9, 2}}
+)
+
+func validSeriesType(typ string) bool {
+	switch typ {
+	case seriesTypeGauge, seriesTypeHistogram, seriesTypeSummary, seriesTypeNativeHistogram:
+		return true
+	default:
+		return false
+	}
+}
+
+// nativeHistogram is a native histogram sample with integer counts.
+type nativeHistogram struct {
+	Count  uint64
+	Sum    float64
+	Schema int32
+	// PositiveSpans and PositiveDeltas encode the positive buckets as in the Prometheus TSDB.
+	PositiveSpans  []bucketSpan
+	PositiveDeltas []int64
+	Timestamp      int64
+}
+
+type bucketSpan struct {
+	Offset int32
+	Length uint32
+}
+
+// fields returns the protobuf fields of the histogram:
+//
+//	message Histogram {
+//	  uint64 count_int = 1; double sum = 3; sint32 schema = 4;
+//	  repeated BucketSpan positive_spans = 11; repeated sint64 positive_deltas = 12; int64 timestamp = 15;
+//	}
+//	message BucketSpan { sint32 offset = 1; uint32 length = 2; }
+func (h nativeHistogram) fields() []protoField {
+	fs := []protoField{
+		varintField(1, h.Count),
+		doubleField(3, h.Sum),
+		sint32Field(4, h.Schema),
+	}
+
+	for _, s := range h.PositiveSpans {
+		fs = append(fs, messageField(11, sint32Field(1, s.Offset), varintField(2, uint64(s.Length))))
+	}
+
+	return append(fs, packedSint64Field(12, h.PositiveDeltas), varintField(15, uint64(h.Timestamp)))
+}
+
+// newNativeHistogram returns a native histogram of the observations with the given sum and timestamp.
+// With schema 0 the bucket with index i holds observations in (2^(i-1), 2^i],
+// so the observations fall into (0.5, 1] and (1, 2] with two observations each.
+func newNativeHistogram(sum float64, timestamp int64) nativeHistogram {
+	return nativeHistogram{
+		Count:          observationsCount,
+		Sum:            sum,
+		Schema:         0,
+		PositiveSpans:  []bucketSpan{{Offset: 0, Length: 2}},
+		PositiveDeltas: []int64{2, 0},
+		Timestamp:      timestamp,
+	}
+}
+
+// withName returns a copy of the label set with the metric name set to the given name.
+func withName(lset []prompb.Label, name string) []prompb.Label {
+	res := make([]prompb.Label, len(lset))
+	for i, l := range lset {
+		if l.Name == "__name__" {
+			l.Value = name
+		}
+
+		res[i] = l
+	}
+
+	return res
+}
+
+// withLabel returns a copy of the label set with the given label added.
+func withLabel(lset []prompb.Label, name, value string) []prompb.Label {
+	res := make([]prompb.Label, len(lset), len(lset)+1)
+	copy(res, lset)
+
+	return append(res, prompb.Label{Name: name, Value: value})
+}
+
+// withoutName returns a copy of the label set without the metric name, as returned by PromQL functions.
+func withoutName(lset []prompb.Label) []prompb.Label {
+	res := make([]prompb.Label, 0, len(lset))
+	for _, l := range lset {
+		if l.Name != "__name__" {
+			res = append(res, l)
+		}
+	}
+
+	return res
+}
+
+func metricName(lset []prompb.Label) string {
+	for _, l := range lset {
+		if l.Name == "__name__" {
+			return l.Value
+		}
+	}
+
+	return ""
+}
+
+// mapSets applies f to every label set.
+func mapSets(sets [][]prompb.Label, f func(lset []prompb.Label) []prompb.Label) [][]prompb.Label {
+	res := make([][]prompb.Label, len(sets))
+	for i, lset := range sets {
+		res[i] = f(lset)
+	}
+
+	return res
+}
+
+// appendTyped appends the series of the given type for the label set to the request.
+func appendTyped(wreq *writeRequest, typ string, lset []prompb.Label, value float64, timestamp int64) {
+	add := func(lset []prompb.Label, v float64) {
+		wreq.Timeseries = append(wreq.Timeseries, prompb.TimeSeries{
+			Labels:  lset,
+			Samples: []prompb.Sample{{Value: v, Timestamp: timestamp}},
+		})
+	}
+
+	name := metricName(lset)
+
+	switch typ {
+	case seriesTypeHistogram:
+		for _, b := range classicBuckets {
+			add(withLabel(withName(lset, name+"_bucket"), "le", formatFloat(b.le)), b.count)
+		}
+	case seriesTypeSummary:
+		for _, q := range summaryQuantiles {
+			add(withLabel(lset, "quantile", formatFloat(q.quantile)), q.value)
+		}
+	case seriesTypeNativeHistogram:
+		wreq.Histograms[len(wreq.Timeseries)] = []nativeHistogram{newNativeHistogram(value, timestamp)}
+		wreq.Timeseries = append(wreq.Timeseries, prompb.TimeSeries{Labels: lset})
+
+		return
+	default:
+		add(lset, value)
+		return
+	}
+
+	add(withName(lset, name+"_sum"), value)
+	add(withName(lset, name+"_count"), observationsCount)
+}
+
+// samplesPerSeries returns the number of samples written for every series of the given type,
+// counting native histogram samples like float samples.
+func samplesPerSeries(typ string) int {
+	switch typ {
+	case seriesTypeHistogram:
+		return len(classicBuckets) + 2
+	case seriesTypeSummary:
+		return len(summaryQuantiles) + 2
+	default:
+		return 1
+	}
+}
+
+// formatFloat formats the value the way Prometheus client libraries format le and quantile labels.
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// typedCheck is a query checking the series written for a series type.
+type typedCheck struct {
+	query string
+	// sets are the label sets of the series the query is expected to return.
+	sets [][]prompb.Label
+	// expected is the value every returned series must have.
+	// If it is nil, the value must be a recent timestamp in milliseconds instead.
+	expected *float64
+}
+
+// typedChecks returns the queries checking that the series of the given type were written correctly.
+// The sums are checked for recency and the quantiles and counts against the values of the observations.
+func typedChecks(typ string, sets [][]prompb.Label) []typedCheck {
+	var (
+		median  = float64(observationsMedian)
+		count   = float64(observationsCount)
+		renamed = func(suffix string) [][]prompb.Label {
+			return mapSets(sets, func(lset []prompb.Label) []prompb.Label { return withName(lset, metricName(lset)+suffix) })
+		}
+		unnamed = mapSets(sets, withoutName)
+	)
+
+	switch typ {
+	case seriesTypeHistogram:
+		return []typedCheck{
+			{query: selector(renamed("_sum")), sets: renamed("_sum")},
+			{query: selector(renamed("_count")), sets: renamed("_count"), expected: &count},
+			{query: fmt.Sprintf("histogram_quantile(0.5, %s)", selector(renamed("_bucket"))), sets: unnamed, expected: &median},
+		}
+	case seriesTypeSummary:
+		quantiles := mapSets(sets, func(lset []prompb.Label) []prompb.Label { return withLabel(lset, "quantile", "0.5") })
+
+		return []typedCheck{
+			{query: selector(renamed("_sum")), sets: renamed("_sum")},
+			{query: selector(renamed("_count")), sets: renamed("_count"), expected: &count},
+			{query: selector(quantiles), sets: quantiles, expected: &median},
+		}
+	case seriesTypeNativeHistogram:
+		return []typedCheck{
+			{query: fmt.Sprintf("histogram_sum(%s)", selector(sets)), sets: unnamed},
+			{query: fmt.Sprintf("histogram_count(%s)", selector(sets)), sets: unnamed, expected: &count},
+			{query: fmt.Sprintf("histogram_quantile(0.5, %s)", selector(sets)), sets: unnamed, expected: &median},
+		}
+	default:
+		return []typedCheck{{query: selector(sets), sets: sets}}
+	}
+}
+
+// readTyped runs the checks of the series type against the query API.
+func readTyped(
+	ctx context.Context,
+	client promapi.Client,
+	endpoint *url.URL,
+	typ string,
+	sets [][]prompb.Label,
+	ts time.Time,
+	latency time.Duration,
+	o prometheus.Observer,
+) error {
+	for _, c := range typedChecks(typ, sets) {
+		vec, err := instantQuery(ctx, client, endpoint, c.query, ts)
+		if err != nil {
+			return err
+		}
+
+		if c.expected == nil {
+			err = verify(c.sets, vec, latency, o)
+		} else {
+			err = verifyValues(c.sets, vec, *c.expected)
+		}
+
+		if err != nil {
+			return errors.Wrapf(err, "query %s", c.query)
+		}
+	}
+
+	return nil
+}
+
+// verifyValues checks that the vector contains exactly the given series and that all of them have the expected value.
+func verifyValues(sets [][]prompb.Label, vec model.Vector, expected float64) error {
+	if len(vec) != len(sets) {
+		return fmt.Errorf("expected %d metrics, got %d", len(sets), len(vec))
+	}
+
+	found, missing := matchSeries(sets, vec)
+	if len(missing) > 0 {
+		return fmt.Errorf("%d of %d series missing, e.g. %s", len(missing), len(sets), selector(missing[:1]))
+	}
+
+	for _, s := range found {
+		if math.Abs(float64(s.Value)-expected) > 1e-9 {
+			return fmt.Errorf("expected value %v, got %v for %s", expected, s.Value, s.Metric)
+		}
+	}
+
+	return nil
+}
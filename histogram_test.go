@@ -0,0 +1,175 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/prometheus/prompb"
+)
+
+func TestAppendTyped(t *testing.T) {
+	lset := []prompb.Label{{Name: "__name__", Value: "up"}, {Name: "instance", Value: "a"}}
+
+	series := func(name string, v float64, extra ...string) prompb.TimeSeries {
+		ls := []prompb.Label{{Name: "__name__", Value: name}, {Name: "instance", Value: "a"}}
+		for i := 0; i < len(extra); i += 2 {
+			ls = append(ls, prompb.Label{Name: extra[i], Value: extra[i+1]})
+		}
+
+		return prompb.TimeSeries{Labels: ls, Samples: []prompb.Sample{{Value: v, Timestamp: 5678}}}
+	}
+
+	for _, tc := range []struct {
+		typ        string
+		series     []prompb.TimeSeries
+		histograms map[int][]nativeHistogram
+	}{
+		{
+			typ:    seriesTypeGauge,
+			series: []prompb.TimeSeries{series("up", 1234)},
+		},
+		{
+			typ: seriesTypeHistogram,
+			series: []prompb.TimeSeries{
+				series("up_bucket", 0, "le", "0.5"),
+				series("up_bucket", 2, "le", "1"),
+				series("up_bucket", 4, "le", "2"),
+				series("up_bucket", 4, "le", "+Inf"),
+				series("up_sum", 1234),
+				series("up_count", 4),
+			},
+		},
+		{
+			typ: seriesTypeSummary,
+			series: []prompb.TimeSeries{
+				series("up", 1, "quantile", "0.5"),
+				series("up", 2, "quantile", "0.9"),
+				series("up_sum", 1234),
+				series("up_count", 4),
+			},
+		},
+		{
+			typ:        seriesTypeNativeHistogram,
+			series:     []prompb.TimeSeries{{Labels: lset}},
+			histograms: map[int][]nativeHistogram{0: {newNativeHistogram(1234, 5678)}},
+		},
+	} {
+		t.Run(tc.typ, func(t *testing.T) {
+			wreq := &writeRequest{Histograms: map[int][]nativeHistogram{}}
+
+			appendTyped(wreq, tc.typ, lset, 1234, 5678)
+
+			if !reflect.DeepEqual(wreq.Timeseries, tc.series) {
+				t.Errorf("expected series %v, got %v", tc.series, wreq.Timeseries)
+			}
+
+			if tc.histograms == nil {
+				tc.histograms = map[int][]nativeHistogram{}
+			}
+
+			if !reflect.DeepEqual(wreq.Histograms, tc.histograms) {
+				t.Errorf("expected histograms %v, got %v", tc.histograms, wreq.Histograms)
+			}
+
+			samples := len(wreq.Histograms)
+			for _, ts := range wreq.Timeseries {
+				samples += len(ts.Samples)
+			}
+
+			if samples != samplesPerSeries(tc.typ) {
+				t.Errorf("expected %d samples per series, got %d", samplesPerSeries(tc.typ), samples)
+			}
+		})
+	}
+}
+
+func TestTypedChecks(t *testing.T) {
+	sets := [][]prompb.Label{
+		{{Name: "__name__", Value: "up"}, {Name: "instance", Value: "a"}},
+		{{Name: "__name__", Value: "up"}, {Name: "instance", Value: "b"}},
+	}
+
+	renamed := func(name string, extra ...prompb.Label) [][]prompb.Label {
+		res := make([][]prompb.Label, len(sets))
+		for i, lset := range sets {
+			res[i] = append(withName(lset, name), extra...)
+		}
+
+		return res
+	}
+
+	var (
+		unnamed   = [][]prompb.Label{{{Name: "instance", Value: "a"}}, {{Name: "instance", Value: "b"}}}
+		quantiles = renamed("up", prompb.Label{Name: "quantile", Value: "0.5"})
+		median    = 1.0
+		count     = 4.0
+	)
+
+	type check struct {
+		query string
+		sets  [][]prompb.Label
+		// expected is nil for checks of recent timestamps.
+		expected *float64
+	}
+
+	for _, tc := range []struct {
+		typ    string
+		checks []check
+	}{
+		{
+			typ:    seriesTypeGauge,
+			checks: []check{{query: `{__name__="up",instance=~"a|b"}`, sets: sets}},
+		},
+		{
+			typ: seriesTypeHistogram,
+			checks: []check{
+				{query: `{__name__="up_sum",instance=~"a|b"}`, sets: renamed("up_sum")},
+				{query: `{__name__="up_count",instance=~"a|b"}`, sets: renamed("up_count"), expected: &count},
+				{query: `histogram_quantile(0.5, {__name__="up_bucket",instance=~"a|b"})`, sets: unnamed, expected: &median},
+			},
+		},
+		{
+			typ: seriesTypeSummary,
+			checks: []check{
+				{query: `{__name__="up_sum",instance=~"a|b"}`, sets: renamed("up_sum")},
+				{query: `{__name__="up_count",instance=~"a|b"}`, sets: renamed("up_count"), expected: &count},
+				{query: `{__name__="up",instance=~"a|b",quantile="0.5"}`, sets: quantiles, expected: &median},
+			},
+		},
+		{
+			typ: seriesTypeNativeHistogram,
+			checks: []check{
+				{query: `histogram_sum({__name__="up",instance=~"a|b"})`, sets: unnamed},
+				{query: `histogram_count({__name__="up",instance=~"a|b"})`, sets: unnamed, expected: &count},
+				{query: `histogram_quantile(0.5, {__name__="up",instance=~"a|b"})`, sets: unnamed, expected: &median},
+			},
+		},
+	} {
+		t.Run(tc.typ, func(t *testing.T) {
+			checks := typedChecks(tc.typ, sets)
+
+			if len(checks) != len(tc.checks) {
+				t.Fatalf("expected %d checks, got %d", len(tc.checks), len(checks))
+			}
+
+			for i, c := range checks {
+				e := tc.checks[i]
+
+				if c.query != e.query {
+					t.Errorf("check %d: expected query %s, got %s", i, e.query, c.query)
+				}
+
+				if !reflect.DeepEqual(c.sets, e.sets) {
+					t.Errorf("check %d: expected series %v, got %v", i, e.sets, c.sets)
+				}
+
+				switch {
+				case e.expected == nil && c.expected != nil:
+					t.Errorf("check %d: expected a recency check, got a check of value %v", i, *c.expected)
+				case e.expected != nil && (c.expected == nil || *c.expected != *e.expected):
+					t.Errorf("check %d: expected a check of value %v, got %v", i, *e.expected, c.expected)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/oklog/run"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// loadTick is the interval at which due requests are handed to the writers of the load mode.
+const loadTick = 10 * time.Millisecond
+
+// loadLatencyBuckets are the buckets of the request durations of the load mode, fine enough to estimate percentiles from.
+var loadLatencyBuckets = prometheus.ExponentialBuckets(0.001, 1.5, 25)
+
+// loadConfig configures the load mode, which writes at a target rate instead of once per period.
+type loadConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// SamplesPerSecond and RequestsPerSecond are the target rate. Only one of them can be set.
+	SamplesPerSecond  float64 `yaml:"samples_per_second"`
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	Writers           int     `yaml:"writers"`
+	// BatchSize is the number of series of the workload written in every request.
+	BatchSize int `yaml:"batch_size"`
+	// Churn is the ratio of the series of the workload replaced by new series every minute.
+	Churn   float64       `yaml:"churn"`
+	Timeout time.Duration `yaml:"timeout"`
+	// Phases are the phases of a time-varying load profile, which replace the rate above.
+	// They can only be set in the config file.
+	Phases []loadPhase `yaml:"phases"`
+}
+
+// register registers the flags configuring the load mode.
+func (c *loadConfig) register(fs *flag.FlagSet) {
+	fs.BoolVar(&c.Enabled, "load", false,
+		"Write at a target rate with a pool of concurrent writers instead of once per period, to capacity-test the write endpoint. "+
+			"The series of --series are written in batches and are not read back, only custom queries are run. "+
+			"Time-varying load profiles can be defined as phases in the load section of the config file.")
+	fs.Float64Var(&c.SamplesPerSecond, "load-samples-per-second", 0,
+		"The target number of samples written per second in load mode. Cannot be combined with --load-requests-per-second.")
+	fs.Float64Var(&c.RequestsPerSecond, "load-requests-per-second", 0,
+		"The target number of requests sent per second in load mode. Cannot be combined with --load-samples-per-second.")
+	fs.IntVar(&c.Writers, "load-writers", 4,
+		"The number of concurrent writers in load mode. Every writer owns an equal share of the series, so their samples stay in order. "+
+			"Requests that are due while all writers are busy are missed.")
+	fs.IntVar(&c.BatchSize, "load-batch-size", 100, "The number of series written in every request in load mode.")
+	fs.Float64Var(&c.Churn, "load-churn", 0,
+		"The ratio of the series replaced by new series every minute in load mode, e.g. 0.1 for 10%. "+
+			"New series take the next indexes of the templated labels.")
+	fs.DurationVar(&c.Timeout, "load-timeout", 10*time.Second, "The timeout of requests in load mode.")
+}
+
+// samplesPerRequest returns the number of samples written in every request of the load mode.
+func (c loadConfig) samplesPerRequest(w *workload) int {
+	return c.BatchSize * samplesPerSeries(w.typ)
+}
+
+// requestRate returns the target number of requests per second.
+func (c loadConfig) requestRate(w *workload) float64 {
+	if c.RequestsPerSecond > 0 {
+		return c.RequestsPerSecond
+	}
+
+	return c.SamplesPerSecond / float64(c.samplesPerRequest(w))
+}
+
+// loadStats counts the results of the requests of a load run.
+type loadStats struct {
+	// The counters are accessed atomically and come first to be 64-bit aligned.
+	requests int64
+	errors   int64
+	samples  int64
+	missed   int64
+
+	start   time.Time
+	end     time.Time
+	latency prometheus.Histogram
+	// target is the last target request rate, which is the highest rate of ramps and steps going up.
+	target float64
+	// keyvals identify the phase of the load profile the stats belong to in the report.
+	keyvals []interface{}
+}
+
+func newLoadStats(keyvals ...interface{}) *loadStats {
+	return &loadStats{
+		start:   time.Now(),
+		latency: prometheus.NewHistogram(prometheus.HistogramOpts{Name: "latency", Buckets: loadLatencyBuckets}),
+		keyvals: keyvals,
+	}
+}
+
+func (s *loadStats) observe(samples int, d time.Duration, err error) {
+	atomic.AddInt64(&s.requests, 1)
+	atomic.AddInt64(&s.samples, int64(samples))
+	s.latency.Observe(d.Seconds())
+
+	if err != nil {
+		atomic.AddInt64(&s.errors, 1)
+	}
+}
+
+// successRatio returns the ratio of successful requests, or 1 if there were none.
+func (s *loadStats) successRatio() float64 {
+	requests := atomic.LoadInt64(&s.requests)
+	if requests == 0 {
+		return 1
+	}
+
+	return 1 - float64(atomic.LoadInt64(&s.errors))/float64(requests)
+}
+
+// report logs the achieved throughput, success ratio and latency percentiles.
+func (s *loadStats) report(l log.Logger, msg string) {
+	end := s.end
+	if end.IsZero() {
+		end = time.Now()
+	}
+
+	var (
+		elapsed  = end.Sub(s.start)
+		requests = float64(atomic.LoadInt64(&s.requests))
+		errs     = float64(atomic.LoadInt64(&s.errors))
+		samples  = float64(atomic.LoadInt64(&s.samples))
+		h        = &dto.Metric{}
+	)
+
+	if err := s.latency.Write(h); err != nil {
+		level.Warn(l).Log("msg", "cannot read latency histogram", "err", err)
+	}
+
+	errorRatio := 0.0
+	if requests > 0 {
+		errorRatio = errs / requests
+	}
+
+	kvs := s.keyvals
+	// The whole run of a load profile has no single target rate.
+	if s.target > 0 {
+		kvs = append(kvs[:len(kvs):len(kvs)], "target_requests_per_second", s.target)
+	}
+
+	level.Info(log.With(l, kvs...)).Log(
+		"msg", msg,
+		"duration", elapsed.Round(time.Millisecond),
+		"requests", requests,
+		"errors", errs,
+		"error_ratio", errorRatio,
+		"success_ratio", s.successRatio(),
+		"missed", atomic.LoadInt64(&s.missed),
+		"requests_per_second", requests/elapsed.Seconds(),
+		"samples_per_second", samples/elapsed.Seconds(),
+		"latency_p50", bucketQuantile(0.5, h.GetHistogram()),
+		"latency_p90", bucketQuantile(0.9, h.GetHistogram()),
+		"latency_p99", bucketQuantile(0.99, h.GetHistogram()),
+	)
+}
+
+// bucketQuantile estimates a quantile of the histogram by linear interpolation within its buckets, like histogram_quantile.
+// Quantiles falling into the +Inf bucket are estimated as the upper bound of the last bucket.
+func bucketQuantile(q float64, h *dto.Histogram) float64 {
+	count := float64(h.GetSampleCount())
+	if count == 0 {
+		return math.NaN()
+	}
+
+	var (
+		rank       = q * count
+		lowerBound float64
+		lowerCount float64
+	)
+
+	for _, b := range h.GetBucket() {
+		upperCount := float64(b.GetCumulativeCount())
+		if upperCount >= rank {
+			if upperCount == lowerCount {
+				return b.GetUpperBound()
+			}
+
+			return lowerBound + (b.GetUpperBound()-lowerBound)*(rank-lowerCount)/(upperCount-lowerCount)
+		}
+
+		lowerBound, lowerCount = b.GetUpperBound(), upperCount
+	}
+
+	return lowerBound
+}
+
+// loadWriter writes batches of its share of the series of the workload.
+type loadWriter struct {
+	// positions are the positions of the series of the workload the writer owns.
+	positions []int
+	next      int
+}
+
+// batch returns the label sets of the next batch of series of the writer.
+// Under churn, the series at a position is replaced by a new one whenever the window of active series moves past it.
+func (lw *loadWriter) batch(w *workload, size, offset int) ([][]prompb.Label, error) {
+	sets := make([][]prompb.Label, 0, size)
+
+	for i := 0; i < size; i++ {
+		p := lw.positions[lw.next]
+		lw.next = (lw.next + 1) % len(lw.positions)
+
+		// The active series are those with indexes in [offset, offset+series), each taking the position of its index modulo series.
+		index := offset + ((p-offset)%w.series+w.series)%w.series
+
+		lset, err := w.labelSet(index)
+		if err != nil {
+			return nil, err
+		}
+
+		sets = append(sets, lset)
+	}
+
+	return sets, nil
+}
+
+// newLoadWriters distributes the positions of the series of the workload between the writers.
+func newLoadWriters(series, writers int) []*loadWriter {
+	ws := make([]*loadWriter, writers)
+	for i := range ws {
+		ws[i] = &loadWriter{}
+	}
+
+	for p := 0; p < series; p++ {
+		ws[p%writers].positions = append(ws[p%writers].positions, p)
+	}
+
+	return ws
+}
+
+// churnOffset returns the index of the oldest active series after the given time under churn.
+func churnOffset(churn float64, series int, elapsed time.Duration) int {
+	return int(churn * float64(series) * elapsed.Minutes())
+}
+
+func addLoadRunGroup(ctx context.Context, g *run.Group, l log.Logger, live *liveOptions, i int, m metrics, cancel func()) {
+	g.Add(func() error {
+		l := log.With(live.tenant(i).logger(l), "component", "load")
+		opts := live.get()
+		level.Info(l).Log("msg", "starting the load writers", "writers", opts.Load.Writers,
+			"phases", len(opts.Load.Phases), "samples_per_request", opts.Load.samplesPerRequest(opts.Workload))
+
+		total, phases := runLoad(ctx, l, live, i, m)
+		reportLoad(l, live.get(), i, total, phases)
+
+		return nil
+	}, func(_ error) {
+		cancel()
+	})
+}
+
+// reportLoad logs the results of every phase of the load profile and of the whole run.
+// The first phase with a success ratio below the success threshold is reported as the load the receiver started to fail at.
+func reportLoad(l log.Logger, opts options, i int, total *loadStats, phases []*loadStats) {
+	if len(opts.Load.Phases) == 0 {
+		total.report(l, "load results")
+		return
+	}
+
+	var failed *loadStats
+
+	for _, s := range phases {
+		s.report(l, "load phase results")
+
+		if failed == nil && s.successRatio() < opts.Tenants[i].SuccessThreshold {
+			failed = s
+		}
+	}
+
+	total.report(l, "load results")
+
+	if failed != nil {
+		level.Warn(log.With(l, failed.keyvals...)).Log("msg", "success ratio fell below the threshold",
+			"success_ratio", failed.successRatio(), "threshold", opts.Tenants[i].SuccessThreshold,
+			"target_requests_per_second", failed.target)
+	}
+}
+
+// runLoad hands due requests to the writers until the context is done or the phases of the load profile are over,
+// and waits for the writers to finish.
+// It returns the stats of the whole run and of every phase and step of the load profile.
+// The target rate is read from the live options on every tick, so reloaded rates are picked up.
+func runLoad(ctx context.Context, l log.Logger, live *liveOptions, i int, m metrics) (*loadStats, []*loadStats) {
+	var (
+		opts    = live.get()
+		writers = newLoadWriters(opts.Workload.series, opts.Load.Writers)
+		// due hands requests to the writers along with the stats of the phase they are due in.
+		due   = make(chan *loadStats)
+		wg    sync.WaitGroup
+		total = newLoadStats()
+	)
+
+	for _, lw := range writers {
+		wg.Add(1)
+
+		go func(lw *loadWriter) {
+			defer wg.Done()
+
+			for s := range due {
+				writeLoad(l, live, i, m, lw, total, s)
+			}
+		}(lw)
+	}
+
+	t := time.NewTicker(loadTick)
+	defer t.Stop()
+
+	var (
+		credit  float64
+		last    = total.start
+		current loadPosition
+		phase   *loadStats
+		phases  []*loadStats
+	)
+
+	stop := func(now time.Time) (*loadStats, []*loadStats) {
+		close(due)
+		wg.Wait()
+
+		total.end = now
+		if phase != nil {
+			phase.end = now
+		}
+
+		return total, phases
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return stop(time.Now())
+		case now := <-t.C:
+			opts := live.get()
+
+			pos, rate, done := opts.Load.at(opts.Workload, now.Sub(total.start))
+			if done {
+				return stop(now)
+			}
+
+			if phase == nil || pos != current {
+				if phase != nil {
+					phase.end = now
+				}
+
+				current, phase = pos, newLoadStats(opts.Load.keyvals(pos)...)
+				phases = append(phases, phase)
+
+				if len(opts.Load.Phases) > 0 {
+					level.Info(log.With(l, phase.keyvals...)).Log("msg", "starting load phase", "target_requests_per_second", rate)
+				}
+			}
+
+			m.loadTargetRequestRate.WithLabelValues(opts.Tenants[i].Name).Set(rate)
+			phase.target = rate
+			if len(opts.Load.Phases) == 0 {
+				total.target = rate
+			}
+
+			credit += rate * now.Sub(last).Seconds()
+			last = now
+
+			for ; credit >= 1; credit-- {
+				select {
+				case due <- phase:
+				default:
+					// All writers are busy, so the request is missed rather than delayed, to keep the rate of the following ones.
+					atomic.AddInt64(&phase.missed, 1)
+					atomic.AddInt64(&total.missed, 1)
+					m.loadMissedRequests.WithLabelValues(opts.Tenants[i].Name).Inc()
+				}
+			}
+		}
+	}
+}
+
+// writeLoad writes the next batch of the writer and records the result in the stats of the run and of the phase.
+func writeLoad(l log.Logger, live *liveOptions, i int, m metrics, lw *loadWriter, total, phase *loadStats) {
+	opts := live.get()
+	t := opts.Tenants[i]
+	samples := opts.Load.samplesPerRequest(opts.Workload)
+
+	sets, err := lw.batch(opts.Workload, opts.Load.BatchSize, churnOffset(opts.Load.Churn, opts.Workload.series, time.Since(total.start)))
+	if err != nil {
+		m.remoteWriteRequests.WithLabelValues(t.Name, "error", classOther).Inc()
+		level.Error(l).Log("msg", "failed to generate series", "err", err)
+
+		return
+	}
+
+	// Requests are bounded by --load-timeout only, so the ones still in flight at the end of the run are included in its report.
+	ctx, cancel := context.WithTimeout(context.Background(), opts.Load.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	err = writeWithRetry(ctx, opts.WriteRetry, m, t.Name, l, sender(opts, t, sets, m, l))
+	elapsed := time.Since(start)
+
+	total.observe(samples, elapsed, err)
+	phase.observe(samples, elapsed, err)
+	m.loadRequestDuration.WithLabelValues(t.Name).Observe(elapsed.Seconds())
+
+	if err != nil {
+		m.remoteWriteRequests.WithLabelValues(t.Name, "error", classify(err)).Inc()
+		m.loadSamples.WithLabelValues(t.Name, "error").Add(float64(samples))
+		level.Debug(l).Log("msg", "failed to make request", "class", classify(err), "err", err)
+
+		return
+	}
+
+	m.remoteWriteRequests.WithLabelValues(t.Name, "success", class2xx).Inc()
+	m.loadSamples.WithLabelValues(t.Name, "success").Add(float64(samples))
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+const (
+	// endpointTypeMetrics writes metrics through the Prometheus remote-write protocol.
+	endpointTypeMetrics = "metrics"
+	// endpointTypeLogs pushes log lines through the Loki push API and reads them back with LogQL.
+	endpointTypeLogs = "logs"
+
+	// logsPushFormatProtobuf pushes snappy compressed protobuf requests, like Promtail does.
+	logsPushFormatProtobuf = "protobuf"
+	// logsPushFormatJSON pushes JSON requests.
+	logsPushFormatJSON = "json"
+
+	// logsNameLabel is the label of the pushed streams set to the name given by --name.
+	logsNameLabel = "name"
+	// logsTimestampField is the logfmt field of the pushed log lines holding the time they were written at.
+	logsTimestampField = "ts"
+)
+
+// logLine returns the log line pushed at the given time, embedding the time as a logfmt field.
+func logLine(ts time.Time) string {
+	return fmt.Sprintf(`level=info msg="up probe" %s=%s`, logsTimestampField, ts.UTC().Format(time.RFC3339Nano))
+}
+
+// logLineTime returns the time embedded in a log line pushed by logLine.
+func logLineTime(line string) (time.Time, error) {
+	for _, f := range strings.Fields(line) {
+		if v := strings.TrimPrefix(f, logsTimestampField+"="); v != f {
+			return time.Parse(time.RFC3339Nano, v)
+		}
+	}
+
+	return time.Time{}, errors.Errorf("no %s field in log line %q", logsTimestampField, line)
+}
+
+// streamLabels formats the label set the way the Loki protobuf push format expects, e.g. {name="up", instance="a"}.
+func streamLabels(lset []prompb.Label) string {
+	strs := make([]string, len(lset))
+	for i, l := range lset {
+		strs[i] = fmt.Sprintf("%s=%q", l.Name, l.Value)
+	}
+
+	sort.Strings(strs)
+
+	return fmt.Sprintf("{%s}", strings.Join(strs, ", "))
+}
+
+// encodeLogs encodes a push request with a single log line for every stream in the given format.
+// It returns the body of the request and its content type.
+func encodeLogs(format string, sets [][]prompb.Label, ts time.Time) ([]byte, string, error) {
+	if format == logsPushFormatJSON {
+		b, err := encodeLogsJSON(sets, ts)
+		return b, "application/json", err
+	}
+
+	b, err := encodeLogsProto(sets, ts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return snappy.Encode(nil, b), "application/x-protobuf", nil
+}
+
+func encodeLogsJSON(sets [][]prompb.Label, ts time.Time) ([]byte, error) {
+	type stream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+
+	streams := make([]stream, len(sets))
+
+	for i, lset := range sets {
+		ls := make(map[string]string, len(lset))
+		for _, l := range lset {
+			ls[l.Name] = l.Value
+		}
+
+		streams[i] = stream{
+			Stream: ls,
+			Values: [][2]string{{strconv.FormatInt(ts.UnixNano(), 10), logLine(ts)}},
+		}
+	}
+
+	return json.Marshal(struct {
+		Streams []stream `json:"streams"`
+	}{streams})
+}
+
+// encodeLogsProto encodes a logproto.PushRequest. The messages are encoded by hand,
+// as the Loki protobuf definitions are not available as a dependency:
+//
+//	message PushRequest { repeated Stream streams = 1; }
+//	message Stream { string labels = 1; repeated Entry entries = 2; }
+//	message Entry { google.protobuf.Timestamp timestamp = 1; string line = 2; }
+func encodeLogsProto(sets [][]prompb.Label, ts time.Time) ([]byte, error) {
+	var (
+		req   = proto.NewBuffer(nil)
+		entry = proto.NewBuffer(nil)
+	)
+
+	// All streams get the same entry, so it is encoded once.
+	timestamp := proto.NewBuffer(nil)
+	if err := encodeFields(timestamp,
+		varintField(1, uint64(ts.Unix())),
+		varintField(2, uint64(ts.Nanosecond())),
+	); err != nil {
+		return nil, err
+	}
+
+	if err := encodeFields(entry,
+		bytesField(1, timestamp.Bytes()),
+		bytesField(2, []byte(logLine(ts))),
+	); err != nil {
+		return nil, err
+	}
+
+	for _, lset := range sets {
+		stream := proto.NewBuffer(nil)
+		if err := encodeFields(stream,
+			bytesField(1, []byte(streamLabels(lset))),
+			bytesField(2, entry.Bytes()),
+		); err != nil {
+			return nil, err
+		}
+
+		if err := encodeFields(req, bytesField(1, stream.Bytes())); err != nil {
+			return nil, err
+		}
+	}
+
+	return req.Bytes(), nil
+}
+
+// pushLogs pushes a log line for every stream to the Loki push endpoint.
+func pushLogs(
+	ctx context.Context,
+	rt http.RoundTripper,
+	endpoint fmt.Stringer,
+	format string,
+	sets [][]prompb.Label,
+	ts time.Time,
+	l log.Logger,
+) error {
+	body, contentType, err := encodeLogs(format, sets, ts)
+	if err != nil {
+		return errors.Wrap(err, "encoding push request")
+	}
+
+	_, err = post(ctx, rt, endpoint, body, http.Header{"Content-Type": []string{contentType}}, l)
+
+	return err
+}
+
+// logsQueryResult is the result of a LogQL query_range request returning streams.
+type logsQueryResult struct {
+	Status string `json:"status"`
+	Data   struct {
+		ResultType string `json:"resultType"`
+		Result     []struct {
+			Stream map[string]string `json:"stream"`
+			Values [][2]string       `json:"values"`
+		} `json:"result"`
+	} `json:"data"`
+}
+
+// readLogs queries the streams of the workload through the Loki query_range API
+// and verifies that the newest log line of every stream was written recently enough.
+// Only log lines written within the latency are queried, so streams without recent lines are missing from the result.
+func readLogs(
+	ctx context.Context,
+	rt http.RoundTripper,
+	endpoint *url.URL,
+	w *workload,
+	_, latency time.Duration,
+	o prometheus.Observer,
+) error {
+	sets, err := w.labelSets()
+	if err != nil {
+		return errors.Wrap(err, "generate series")
+	}
+
+	end := time.Now()
+
+	// Copy URL to avoid modifying the passed value.
+	u := new(url.URL)
+	*u = *endpoint
+
+	q := u.Query()
+	q.Set("query", selector(sets))
+	q.Set("start", strconv.FormatInt(end.Add(-latency).UnixNano(), 10))
+	q.Set("end", strconv.FormatInt(end.UnixNano(), 10))
+	q.Set("direction", "backward")
+	// Lines are returned newest first, so the lines of the last push of all streams come first.
+	q.Set("limit", strconv.Itoa(len(sets)))
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return errors.Wrap(err, "creating request")
+	}
+
+	res, err := (&http.Client{Transport: rt}).Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(err, "query request failed")
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		return errors.Wrap(newStatusError(res), "query request failed")
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return errors.Wrap(err, "reading query response")
+	}
+
+	var result logsQueryResult
+	if err := json.Unmarshal(body, &result); err != nil {
+		return errors.Wrap(err, "query response parse failed")
+	}
+
+	if result.Data.ResultType != "streams" {
+		return errors.Errorf("query response parse failed: unexpected result type %q", result.Data.ResultType)
+	}
+
+	vec := make(model.Vector, 0, len(result.Data.Result))
+
+	for _, s := range result.Data.Result {
+		var newest time.Time
+
+		for _, v := range s.Values {
+			t, err := logLineTime(v[1])
+			if err != nil {
+				return errors.Wrap(err, "unexpected log line")
+			}
+
+			if t.After(newest) {
+				newest = t
+			}
+		}
+
+		if newest.IsZero() {
+			continue
+		}
+
+		metric := make(model.Metric, len(s.Stream))
+		for n, v := range s.Stream {
+			metric[model.LabelName(n)] = model.LabelValue(v)
+		}
+
+		// Like the value of written metrics, the value is the time the line was written at in milliseconds.
+		ms := newest.UnixNano() / int64(time.Millisecond)
+		vec = append(vec, &model.Sample{Metric: metric, Value: model.SampleValue(ms), Timestamp: model.Time(ms)})
+	}
+
+	return verify(sets, vec, latency, o)
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// The messages of logproto.PushRequest used to decode the output of encodeLogsProto.
+type logsPBPushRequest struct {
+	Streams []*logsPBStream `protobuf:"bytes,1,rep,name=streams,proto3"`
+}
+
+func (m *logsPBPushRequest) Reset()         { *m = logsPBPushRequest{} }
+func (m *logsPBPushRequest) String() string { return proto.CompactTextString(m) }
+func (*logsPBPushRequest) ProtoMessage()    {}
+
+type logsPBStream struct {
+	Labels  string         `protobuf:"bytes,1,opt,name=labels,proto3"`
+	Entries []*logsPBEntry `protobuf:"bytes,2,rep,name=entries,proto3"`
+}
+
+func (m *logsPBStream) Reset()         { *m = logsPBStream{} }
+func (m *logsPBStream) String() string { return proto.CompactTextString(m) }
+func (*logsPBStream) ProtoMessage()    {}
+
+type logsPBEntry struct {
+	Timestamp *logsPBTimestamp `protobuf:"bytes,1,opt,name=timestamp,proto3"`
+	Line      string           `protobuf:"bytes,2,opt,name=line,proto3"`
+}
+
+func (m *logsPBEntry) Reset()         { *m = logsPBEntry{} }
+func (m *logsPBEntry) String() string { return proto.CompactTextString(m) }
+func (*logsPBEntry) ProtoMessage()    {}
+
+type logsPBTimestamp struct {
+	Seconds int64 `protobuf:"varint,1,opt,name=seconds,proto3"`
+	Nanos   int32 `protobuf:"varint,2,opt,name=nanos,proto3"`
+}
+
+func (m *logsPBTimestamp) Reset()         { *m = logsPBTimestamp{} }
+func (m *logsPBTimestamp) String() string { return proto.CompactTextString(m) }
+func (*logsPBTimestamp) ProtoMessage()    {}
+
+var logsTestSets = [][]prompb.Label{
+	{{Name: "name", Value: "up"}, {Name: "instance", Value: "up-0"}},
+	{{Name: "name", Value: "up"}, {Name: "instance", Value: "up-1"}},
+}
+
+func TestEncodeLogsProto(t *testing.T) {
+	ts := time.Date(2021, 3, 4, 5, 6, 7, 89, time.UTC)
+
+	b, contentType, err := encodeLogs(logsPushFormatProtobuf, logsTestSets, ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if contentType != "application/x-protobuf" {
+		t.Errorf("expected content type application/x-protobuf, got %s", contentType)
+	}
+
+	b, err = snappy.Decode(nil, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var req logsPBPushRequest
+	if err := proto.Unmarshal(b, &req); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{`{instance="up-0", name="up"}`, `{instance="up-1", name="up"}`}
+
+	if len(req.Streams) != len(expected) {
+		t.Fatalf("expected %d streams, got %d", len(expected), len(req.Streams))
+	}
+
+	for i, s := range req.Streams {
+		if s.Labels != expected[i] {
+			t.Errorf("stream %d: expected labels %s, got %s", i, expected[i], s.Labels)
+		}
+
+		if len(s.Entries) != 1 {
+			t.Fatalf("stream %d: expected 1 entry, got %d", i, len(s.Entries))
+		}
+
+		e := s.Entries[0]
+
+		if e.Timestamp == nil || time.Unix(e.Timestamp.Seconds, int64(e.Timestamp.Nanos)).UTC() != ts {
+			t.Errorf("stream %d: expected timestamp %s, got %v", i, ts, e.Timestamp)
+		}
+
+		if e.Line != logLine(ts) {
+			t.Errorf("stream %d: expected line %q, got %q", i, logLine(ts), e.Line)
+		}
+	}
+}
+
+func TestEncodeLogsJSON(t *testing.T) {
+	ts := time.Date(2021, 3, 4, 5, 6, 7, 89, time.UTC)
+
+	b, contentType, err := encodeLogs(logsPushFormatJSON, logsTestSets, ts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if contentType != "application/json" {
+		t.Errorf("expected content type application/json, got %s", contentType)
+	}
+
+	var req struct {
+		Streams []struct {
+			Stream map[string]string `json:"stream"`
+			Values [][]string        `json:"values"`
+		} `json:"streams"`
+	}
+
+	if err := json.Unmarshal(b, &req); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(req.Streams) != len(logsTestSets) {
+		t.Fatalf("expected %d streams, got %d", len(logsTestSets), len(req.Streams))
+	}
+
+	for i, s := range req.Streams {
+		if stream := map[string]string{"name": "up", "instance": "up-" + strconv.Itoa(i)}; !reflect.DeepEqual(s.Stream, stream) {
+			t.Errorf("stream %d: expected labels %v, got %v", i, stream, s.Stream)
+		}
+
+		// Timestamps are strings of nanoseconds since the epoch.
+		if values := [][]string{{strconv.FormatInt(ts.UnixNano(), 10), logLine(ts)}}; !reflect.DeepEqual(s.Values, values) {
+			t.Errorf("stream %d: expected values %q, got %q", i, values, s.Values)
+		}
+	}
+}
+
+func TestReadLogs(t *testing.T) {
+	w, err := newWorkload([]prompb.Label{
+		{Name: "name", Value: "up"},
+		{Name: "instance", Value: "up-{{.Index}}"},
+	}, 2, seriesTypeGauge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+
+	type stream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+
+	entry := func(ts time.Time) [2]string {
+		return [2]string{strconv.FormatInt(ts.UnixNano(), 10), logLine(ts)}
+	}
+
+	for _, tc := range []struct {
+		name    string
+		streams []stream
+		invalid bool
+	}{
+		{
+			name: "recent lines",
+			streams: []stream{
+				{Stream: map[string]string{"name": "up", "instance": "up-0"}, Values: [][2]string{entry(now)}},
+				{Stream: map[string]string{"name": "up", "instance": "up-1"}, Values: [][2]string{entry(now.Add(-time.Second))}},
+			},
+		},
+		{
+			name: "newest line recent",
+			streams: []stream{
+				{Stream: map[string]string{"name": "up", "instance": "up-0"}, Values: [][2]string{entry(now.Add(-time.Minute)), entry(now)}},
+				{Stream: map[string]string{"name": "up", "instance": "up-1"}, Values: [][2]string{entry(now)}},
+			},
+		},
+		{
+			// The timestamp of the entry is recent, but the line was written too long ago.
+			name: "line too old",
+			streams: []stream{
+				{Stream: map[string]string{"name": "up", "instance": "up-0"}, Values: [][2]string{entry(now)}},
+				{
+					Stream: map[string]string{"name": "up", "instance": "up-1"},
+					Values: [][2]string{{strconv.FormatInt(now.UnixNano(), 10), logLine(now.Add(-time.Minute))}},
+				},
+			},
+			invalid: true,
+		},
+		{
+			name: "line without timestamp",
+			streams: []stream{
+				{Stream: map[string]string{"name": "up", "instance": "up-0"}, Values: [][2]string{entry(now)}},
+				{
+					Stream: map[string]string{"name": "up", "instance": "up-1"},
+					Values: [][2]string{{strconv.FormatInt(now.UnixNano(), 10), "level=info"}},
+				},
+			},
+			invalid: true,
+		},
+		{
+			name: "stream missing",
+			streams: []stream{
+				{Stream: map[string]string{"name": "up", "instance": "up-0"}, Values: [][2]string{entry(now)}},
+			},
+			invalid: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Query().Get("direction") != "backward" || r.URL.Query().Get("limit") != "2" {
+					t.Errorf("expected the newest line of each stream to be queried, got %s", r.URL.RawQuery)
+				}
+
+				res := map[string]interface{}{
+					"status": "success",
+					"data":   map[string]interface{}{"resultType": "streams", "result": tc.streams},
+				}
+
+				_ = json.NewEncoder(w).Encode(res)
+			}))
+			defer srv.Close()
+
+			endpoint, err := url.Parse(srv.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			o := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "latency"})
+
+			err = readLogs(context.Background(), http.DefaultTransport, endpoint, w, 0, 10*time.Second, o)
+			if tc.invalid && err == nil {
+				t.Error("expected an error")
+			}
+
+			if !tc.invalid && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
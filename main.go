@@ -4,7 +4,6 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"flag"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -14,15 +13,15 @@ import (
 	"net/url"
 	"os"
 	"os/signal"
+	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
-	"github.com/gogo/protobuf/proto"
-	"github.com/golang/snappy"
 	"github.com/oklog/run"
 	"github.com/pkg/errors"
 	promapi "github.com/prometheus/client_golang/api"
@@ -74,6 +73,30 @@ func (la *labelArg) Set(v string) error {
 	return nil
 }
 
+// UnmarshalYAML reads labels from a mapping of label names to values.
+func (la *labelArg) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var ls map[string]string
+	if err := unmarshal(&ls); err != nil {
+		return err
+	}
+
+	lset := make([]prompb.Label, 0, len(ls))
+
+	for name, val := range ls {
+		if !model.LabelName.IsValid(model.LabelName(name)) {
+			return errors.Errorf("unsupported format for label %s", name)
+		}
+
+		lset = append(lset, prompb.Label{Name: name, Value: val})
+	}
+
+	sort.Slice(lset, func(i, j int) bool { return lset[i].Name < lset[j].Name })
+
+	*la = lset
+
+	return nil
+}
+
 type queryResult struct {
 	Type   model.ValueType `json:"resultType"`
 	Result interface{}     `json:"result"`
@@ -120,27 +143,69 @@ func (qr *queryResult) UnmarshalJSON(b []byte) error {
 
 type options struct {
 	LogLevel          level.Option
-	WriteEndpoint     *url.URL
-	ReadEndpoint      *url.URL
+	EndpointType      string
+	LogsPushFormat    string
+	Tenants           []tenant
 	Labels            labelArg
 	Listen            string
 	Name              string
-	Token             TokenProvider
+	Series            int
+	Workload          *workload
+	TLS               tlsConfig
+	WriteRetry        retryConfig
+	WriteCompression  string
+	Load              loadConfig
 	Queries           []querySpec
 	Period            time.Duration
 	Duration          time.Duration
 	Latency           time.Duration
 	InitialQueryDelay time.Duration
+	QueryConcurrency  int
+	QueriesThreshold  float64
 	SuccessThreshold  float64
+	ReloadInterval    time.Duration
+	// Files are the files the options were read from, which are reloaded when they change.
+	Files []string
+	// Transports are the transports built for the options, whose idle connections are closed once they are replaced.
+	Transports []http.RoundTripper
 }
 
 type metrics struct {
 	remoteWriteRequests     *prometheus.CounterVec
+	remoteWriteRetries      *prometheus.CounterVec
+	remoteWriteRecovered    *prometheus.CounterVec
+	remoteWriteDropped      *prometheus.CounterVec
 	queryResponses          *prometheus.CounterVec
-	metricValueDifference   prometheus.Histogram
+	metricValueDifference   *prometheus.HistogramVec
 	customQueryExecuted     *prometheus.CounterVec
 	customQueryErrors       *prometheus.CounterVec
 	customQueryLastDuration *prometheus.GaugeVec
+	customQuerySlow         *prometheus.CounterVec
+	customQueryWarnings     *prometheus.CounterVec
+	customQueryDuration     *prometheus.HistogramVec
+	customQuerySeries       *prometheus.HistogramVec
+	customQuerySamples      *prometheus.HistogramVec
+	customQueryLastSeries   *prometheus.GaugeVec
+	customQueryLastSamples  *prometheus.GaugeVec
+	// customQueryAssertionFailures counts queries that succeeded but whose results did not meet their expectation.
+	customQueryAssertionFailures *prometheus.CounterVec
+
+	// remoteWriteUncompressedBytes and remoteWriteCompressedBytes observe the size of remote write requests before and after compression.
+	remoteWriteUncompressedBytes *prometheus.HistogramVec
+	remoteWriteCompressedBytes   *prometheus.HistogramVec
+
+	// remoteWriteV2Fallbacks counts remote-write 2.0 requests sent again as remote-write 1.0, whose written counts are not checked.
+	remoteWriteV2Fallbacks *prometheus.CounterVec
+
+	churnedSeries *prometheus.CounterVec
+
+	loadRequestDuration   *prometheus.HistogramVec
+	loadSamples           *prometheus.CounterVec
+	loadMissedRequests    *prometheus.CounterVec
+	loadTargetRequestRate *prometheus.GaugeVec
+
+	configReloads              *prometheus.CounterVec
+	configLastReloadSuccessful prometheus.Gauge
 }
 
 func main() {
@@ -160,6 +225,13 @@ func main() {
 	reg := prometheus.NewRegistry()
 	m := registerMetrics(reg)
 
+	for _, t := range opts.Tenants {
+		registerCollectors(reg, t.collectors()...)
+	}
+
+	live := newLiveOptions(opts)
+	r := newReloader(log.With(l, "component", "reload"), reg, m, live, parseFlags)
+
 	g := &run.Group{}
 	{
 		// Signal chans must be buffered.
@@ -179,6 +251,7 @@ func main() {
 		router := http.NewServeMux()
 		router.Handle("/metrics", promhttp.InstrumentMetricHandler(reg, promhttp.HandlerFor(reg, promhttp.HandlerOpts{})))
 		router.HandleFunc("/debug/pprof/", pprof.Index)
+		router.Handle("/-/reload", r)
 
 		srv := &http.Server{Addr: opts.Listen, Handler: router}
 
@@ -199,6 +272,23 @@ func main() {
 		})
 	}
 
+	// Schedule reloads of the configuration.
+	{
+		// Signal chans must be buffered.
+		hup := make(chan os.Signal, 1)
+		ctx, cancel := context.WithCancel(context.Background())
+
+		g.Add(func() error {
+			signal.Notify(hup, syscall.SIGHUP)
+			r.run(ctx, hup, opts.ReloadInterval)
+
+			return nil
+		}, func(_ error) {
+			signal.Stop(hup)
+			cancel()
+		})
+	}
+
 	ctx := context.Background()
 
 	var cancel context.CancelFunc
@@ -208,67 +298,157 @@ func main() {
 		ctx, cancel = context.WithCancel(ctx)
 	}
 
-	if opts.WriteEndpoint != nil {
-		g.Add(func() error {
-			l := log.With(l, "component", "writer")
-			level.Info(l).Log("msg", "starting the writer")
-
-			return runPeriodically(ctx, opts, m.remoteWriteRequests, l, func(rCtx context.Context) {
-				if err := write(rCtx, opts.WriteEndpoint, opts.Token, generate(opts.Labels), l); err != nil {
-					m.remoteWriteRequests.WithLabelValues("error").Inc()
-					level.Error(l).Log("msg", "failed to make request", "err", err)
-				} else {
-					m.remoteWriteRequests.WithLabelValues("success").Inc()
-				}
-			})
-		}, func(_ error) {
-			cancel()
-		})
+	for i, t := range opts.Tenants {
+		switch {
+		case t.WriteEndpoint != nil && opts.Load.Enabled:
+			addLoadRunGroup(ctx, g, l, live, i, m, cancel)
+		case t.WriteEndpoint != nil:
+			addWriterRunGroup(ctx, g, l, live, i, m, cancel)
+		}
+
+		// In load mode the written series change with every request, so they are not read back.
+		if t.ReadEndpoint != nil && t.WriteEndpoint != nil && !opts.Load.Enabled {
+			addReaderRunGroup(ctx, g, l, live, i, m, cancel)
+		}
+
+		if t.ReadEndpoint != nil && opts.Queries != nil {
+			addCustomQueryRunGroup(ctx, g, l, live, i, m, cancel)
+		}
 	}
 
-	if opts.ReadEndpoint != nil && opts.WriteEndpoint != nil {
-		g.Add(func() error {
-			l := log.With(l, "component", "reader")
-			level.Info(l).Log("msg", "starting the reader")
+	if err := g.Run(); err != nil {
+		level.Error(l).Log("msg", "run group exited with error", "err", err)
+		os.Exit(1)
+	}
 
-			// Wait for at least one period before start reading metrics.
-			level.Info(l).Log("msg", "waiting for initial delay before querying for metrics")
-			select {
-			case <-ctx.Done():
-				return nil
-			case <-time.After(opts.InitialQueryDelay):
+	// All components have finished, so their results are complete and can be evaluated together.
+	if err := evaluate(l, m, live.get()); err != nil {
+		level.Error(l).Log("msg", "results did not meet the thresholds", "err", err)
+		os.Exit(1)
+	}
+
+	level.Info(l).Log("msg", "up completed its mission!")
+}
+
+func addWriterRunGroup(ctx context.Context, g *run.Group, l log.Logger, live *liveOptions, i int, m metrics, cancel func()) {
+	g.Add(func() error {
+		l := log.With(live.tenant(i).logger(l), "component", "writer")
+		level.Info(l).Log("msg", "starting the writer")
+
+		// last is the generation of churn of the previous request, to count rotations.
+		// Every request runs in its own goroutine, so it is accessed atomically.
+		var last int64
+
+		runPeriodically(ctx, live.get().Period, func(rCtx context.Context) {
+			opts := live.get()
+			t := opts.Tenants[i]
+			w := opts.Workload
+
+			generation := w.generation(time.Now())
+			if previous := atomic.SwapInt64(&last, generation); w.churn != nil && previous != 0 && generation != previous {
+				m.churnedSeries.WithLabelValues(t.Name).Add(float64(w.churn.rotated(w.series)))
+				level.Info(l).Log("msg", "rotated series", "label", w.churn.Label, "series", w.churn.rotated(w.series), "generation", generation)
 			}
 
-			level.Info(l).Log("msg", "start querying for metrics")
+			sets, err := w.labelSetsAt(generation)
+			if err != nil {
+				m.remoteWriteRequests.WithLabelValues(t.Name, "error", classOther).Inc()
+				level.Error(l).Log("msg", "failed to generate series", "err", err)
 
-			return runPeriodically(ctx, opts, m.queryResponses, l, func(rCtx context.Context) {
-				if err := read(rCtx, opts.ReadEndpoint, opts.Labels, -1*opts.InitialQueryDelay, opts.Latency, m); err != nil {
-					m.queryResponses.WithLabelValues("error").Inc()
-					level.Error(l).Log("msg", "failed to query", "err", err)
-				} else {
-					m.queryResponses.WithLabelValues("success").Inc()
-				}
-			})
-		}, func(_ error) {
-			cancel()
+				return
+			}
+
+			send := sender(opts, t, sets, m, l)
+
+			if err := writeWithRetry(rCtx, opts.WriteRetry, m, t.Name, l, send); err != nil {
+				m.remoteWriteRequests.WithLabelValues(t.Name, "error", classify(err)).Inc()
+				level.Error(l).Log("msg", "failed to make request", "class", classify(err), "err", err)
+			} else {
+				m.remoteWriteRequests.WithLabelValues(t.Name, "success", class2xx).Inc()
+			}
 		})
+
+		return nil
+	}, func(_ error) {
+		cancel()
+	})
+}
+
+// sender returns a function sending the series of the workload to the write endpoint of the tenant.
+// Retries send the same request again, so it is generated once.
+func sender(opts options, t tenant, sets [][]prompb.Label, m metrics, l log.Logger) func(ctx context.Context) error {
+	if opts.EndpointType == endpointTypeLogs {
+		ts := time.Now()
+
+		return func(ctx context.Context) error {
+			return pushLogs(ctx, t.WriteTransport, t.WriteEndpoint, opts.LogsPushFormat, sets, ts, l)
+		}
 	}
 
-	if opts.ReadEndpoint != nil && opts.Queries != nil {
-		addCustomQueryRunGroup(ctx, g, l, opts, m, cancel)
+	if t.WriteProtocol == writeProtocolOTLPProtobuf || t.WriteProtocol == writeProtocolOTLPJSON {
+		req := generateOTLP(sets)
+
+		return func(ctx context.Context) error {
+			return writeOTLP(ctx, t.WriteTransport, t.WriteEndpoint, t.WriteProtocol, req, l)
+		}
 	}
 
-	if err := g.Run(); err != nil {
-		level.Error(l).Log("msg", "run group exited with error", "err", err)
-		os.Exit(1)
+	wreq := generate(sets, opts.Workload)
+	c := newCompressor(opts.WriteCompression, t.Name, m)
+
+	if t.WriteProtocol == writeProtocolRemoteWriteV2 {
+		fallbacks := m.remoteWriteV2Fallbacks.WithLabelValues(t.Name)
+
+		return func(ctx context.Context) error {
+			return writeV2(ctx, t.WriteTransport, t.WriteEndpoint, wreq, c, remoteWriteV1Endpoints, fallbacks, l)
+		}
 	}
 
-	level.Info(l).Log("msg", "up completed its mission!")
+	return func(ctx context.Context) error {
+		return write(ctx, t.WriteTransport, t.WriteEndpoint, wreq, c, l)
+	}
+}
+
+func addReaderRunGroup(ctx context.Context, g *run.Group, l log.Logger, live *liveOptions, i int, m metrics, cancel func()) {
+	g.Add(func() error {
+		t := live.tenant(i)
+		l := log.With(t.logger(l), "component", "reader")
+		level.Info(l).Log("msg", "starting the reader")
+
+		// Wait for at least one period before start reading metrics.
+		level.Info(l).Log("msg", "waiting for initial delay before querying for metrics")
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(live.get().InitialQueryDelay):
+		}
+
+		level.Info(l).Log("msg", "start querying for metrics", "protocol", t.ReadProtocol)
+
+		o := m.metricValueDifference.WithLabelValues(t.Name)
+
+		runPeriodically(ctx, live.get().Period, func(rCtx context.Context) {
+			opts := live.get()
+			t := opts.Tenants[i]
+			read := reader(opts.EndpointType, t.ReadProtocol)
+
+			if err := read(rCtx, t.ReadTransport, t.ReadEndpoint, opts.Workload, -1*opts.InitialQueryDelay, opts.Latency, o); err != nil {
+				m.queryResponses.WithLabelValues(t.Name, "error").Inc()
+				level.Error(l).Log("msg", "failed to query", "err", err)
+			} else {
+				m.queryResponses.WithLabelValues(t.Name, "success").Inc()
+			}
+		})
+
+		return nil
+	}, func(_ error) {
+		cancel()
+	})
 }
 
-func addCustomQueryRunGroup(ctx context.Context, g *run.Group, l log.Logger, opts options, m metrics, cancel func()) {
+func addCustomQueryRunGroup(ctx context.Context, g *run.Group, l log.Logger, live *liveOptions, i int, m metrics, cancel func()) {
 	g.Add(func() error {
-		l := log.With(l, "component", "query-reader")
+		l := log.With(live.tenant(i).logger(l), "component", "query-reader")
 		level.Info(l).Log("msg", "starting the reader for queries")
 
 		// Wait for at least one period before start reading metrics.
@@ -276,60 +456,94 @@ func addCustomQueryRunGroup(ctx context.Context, g *run.Group, l log.Logger, opt
 		select {
 		case <-ctx.Done():
 			return nil
-		case <-time.After(opts.InitialQueryDelay):
+		case <-time.After(live.get().InitialQueryDelay):
 		}
 
 		level.Info(l).Log("msg", "start querying for specified queries")
 
-		for {
-			select {
-			case <-ctx.Done():
-				return nil
-			default:
-				for _, q := range opts.Queries {
-					select {
-					case <-ctx.Done():
-						return nil
-					default:
-						t := time.Now()
-						warn, err := query(
-							ctx,
-							l,
-							opts.ReadEndpoint,
-							opts.Token,
-							q,
-						)
-						duration := time.Since(t).Seconds()
-						if err != nil {
-							level.Info(l).Log(
-								"msg", "failed to execute specified query",
-								"name", q.Name,
-								"duration", duration,
-								"warnings", fmt.Sprintf("%#+v", warn),
-								"err", err,
-							)
-							m.customQueryErrors.WithLabelValues(q.Name).Inc()
-						} else {
-							level.Debug(l).Log("msg", "successfully executed specified query",
-								"name", q.Name,
-								"duration", duration,
-								"warnings", fmt.Sprintf("%#+v", warn),
-							)
-							m.customQueryLastDuration.WithLabelValues(q.Name).Set(duration)
-						}
-						m.customQueryExecuted.WithLabelValues(q.Name).Inc()
-					}
-				}
-			}
-		}
+		scheduleQueries(ctx, l, live, i, m, live.get().QueryConcurrency)
+
+		return nil
 	}, func(_ error) {
 		cancel()
 	})
 }
 
-func runPeriodically(ctx context.Context, opts options, c *prometheus.CounterVec, l log.Logger, f func(rCtx context.Context)) error {
+// Outcomes of custom queries, exposed as the outcome label of up_custom_query_duration_seconds.
+const (
+	outcomeSuccess          = "success"
+	outcomeError            = "error"
+	outcomeAssertionFailure = "assertion_failure"
+)
+
+// runCustomQuery executes the query and records its outcome.
+func runCustomQuery(ctx context.Context, l log.Logger, tn tenant, q querySpec, m metrics) {
+	t := time.Now()
+	res, warn, err := query(
+		ctx,
+		l,
+		tn.ReadTransport,
+		tn.ReadEndpoint,
+		q,
+	)
+	elapsed := time.Since(t)
+	duration := elapsed.Seconds()
+
+	m.customQueryWarnings.WithLabelValues(tn.Name, q.Name).Add(float64(len(warn)))
+
+	if err == nil && q.LatencySLO != nil && elapsed > q.LatencySLO.Target {
+		m.customQuerySlow.WithLabelValues(tn.Name, q.Name).Inc()
+	}
+
+	if err == nil {
+		series, samples := seriesOf(res)
+		m.customQueryLastSeries.WithLabelValues(tn.Name, q.Name).Set(float64(len(series)))
+		m.customQueryLastSamples.WithLabelValues(tn.Name, q.Name).Set(float64(len(samples)))
+		m.customQuerySeries.WithLabelValues(tn.Name, q.Name).Observe(float64(len(series)))
+		m.customQuerySamples.WithLabelValues(tn.Name, q.Name).Observe(float64(len(samples)))
+
+		if q.Expect != nil {
+			err = q.Expect.check(res)
+		}
+	}
+
+	var aerr assertionError
+
+	switch {
+	case errors.As(err, &aerr):
+		level.Info(l).Log(
+			"msg", "specified query returned unexpected result",
+			"name", q.Name,
+			"duration", duration,
+			"err", err,
+		)
+		m.customQueryAssertionFailures.WithLabelValues(tn.Name, q.Name).Inc()
+		m.customQueryDuration.WithLabelValues(tn.Name, q.Name, outcomeAssertionFailure).Observe(duration)
+	case err != nil:
+		level.Info(l).Log(
+			"msg", "failed to execute specified query",
+			"name", q.Name,
+			"duration", duration,
+			"warnings", fmt.Sprintf("%#+v", warn),
+			"err", err,
+		)
+		m.customQueryErrors.WithLabelValues(tn.Name, q.Name).Inc()
+		m.customQueryDuration.WithLabelValues(tn.Name, q.Name, outcomeError).Observe(duration)
+	default:
+		level.Debug(l).Log("msg", "successfully executed specified query",
+			"name", q.Name,
+			"duration", duration,
+			"warnings", fmt.Sprintf("%#+v", warn),
+		)
+		m.customQueryLastDuration.WithLabelValues(tn.Name, q.Name).Set(duration)
+		m.customQueryDuration.WithLabelValues(tn.Name, q.Name, outcomeSuccess).Observe(duration)
+	}
+	m.customQueryExecuted.WithLabelValues(tn.Name, q.Name).Inc()
+}
+
+func runPeriodically(ctx context.Context, period time.Duration, f func(rCtx context.Context)) {
 	var (
-		t        = time.NewTicker(opts.Period)
+		t        = time.NewTicker(period)
 		deadline time.Time
 		rCtx     context.Context
 		rCancel  context.CancelFunc
@@ -340,7 +554,7 @@ func runPeriodically(ctx context.Context, opts options, c *prometheus.CounterVec
 		case <-t.C:
 			// NOTICE: Do not propagate parent context to prevent cancellation of in-flight request.
 			// It will be cancelled after the deadline.
-			deadline = time.Now().Add(opts.Period)
+			deadline = time.Now().Add(period)
 			rCtx, rCancel = context.WithDeadline(context.Background(), deadline)
 
 			// Will only get scheduled once per period and guaranteed to get cancelled after deadline.
@@ -352,6 +566,11 @@ func runPeriodically(ctx context.Context, opts options, c *prometheus.CounterVec
 		case <-ctx.Done():
 			t.Stop()
 
+			// Nothing was scheduled if it got cancelled before the first tick.
+			if rCtx == nil {
+				return
+			}
+
 			select {
 			// If it gets immediately cancelled, zero value of deadline won't cause a lock!
 			case <-time.After(time.Until(deadline)):
@@ -359,7 +578,7 @@ func runPeriodically(ctx context.Context, opts options, c *prometheus.CounterVec
 			case <-rCtx.Done():
 			}
 
-			return reportResults(l, c, opts.SuccessThreshold)
+			return
 		}
 	}
 }
@@ -371,32 +590,21 @@ type TokenProvider interface {
 type instantQueryRoundTripper struct {
 	l       log.Logger
 	r       http.RoundTripper
-	t       TokenProvider
 	TraceID string
 }
 
-func newInstantQueryRoundTripper(l log.Logger, t TokenProvider, r http.RoundTripper) *instantQueryRoundTripper {
+func newInstantQueryRoundTripper(l log.Logger, r http.RoundTripper) *instantQueryRoundTripper {
 	if r == nil {
 		r = http.DefaultTransport
 	}
 
 	return &instantQueryRoundTripper{
 		l: l,
-		t: t,
 		r: r,
 	}
 }
 
 func (r *instantQueryRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
-	token, err := r.t.Get()
-	if err != nil {
-		return nil, err
-	}
-
-	if token != "" {
-		req.Header.Add("Authorization", "Bearer "+token)
-	}
-
 	resp, err := r.r.RoundTrip(req)
 	if err != nil {
 		return resp, err
@@ -410,11 +618,12 @@ func (r *instantQueryRoundTripper) RoundTrip(req *http.Request) (*http.Response,
 func query(
 	ctx context.Context,
 	l log.Logger,
+	rt http.RoundTripper,
 	endpoint *url.URL,
-	t TokenProvider,
 	query querySpec,
-) (promapiv1.Warnings, error) {
+) (model.Value, promapiv1.Warnings, error) {
 	var (
+		res  model.Value
 		warn promapiv1.Warnings
 		err  error
 	)
@@ -426,7 +635,7 @@ func query(
 	*u = *endpoint
 	u.Path = ""
 
-	r := newInstantQueryRoundTripper(l, t, nil)
+	r := newInstantQueryRoundTripper(l, rt)
 
 	c, err := promapi.NewClient(promapi.Config{
 		Address:      u.String(),
@@ -434,22 +643,25 @@ func query(
 	})
 	if err != nil {
 		err = fmt.Errorf("create new API client: %w", err)
-		return warn, err
+		return res, warn, err
 	}
 
 	a := promapiv1.NewAPI(c)
 
-	var res model.Value
+	if query.Range != nil {
+		res, warn, err = a.QueryRange(ctx, query.Query, query.Range.window(time.Now()))
+	} else {
+		res, warn, err = a.Query(ctx, query.Query, time.Now())
+	}
 
-	res, warn, err = a.Query(ctx, query.Query, time.Now())
 	if err != nil {
 		err = fmt.Errorf("querying: %w", err)
-		return warn, err
+		return res, warn, err
 	}
 
 	level.Debug(l).Log("msg", "request finished", "name", query.Name, "response", res.String(), "trace-id", r.TraceID)
 
-	return warn, err
+	return res, warn, err
 }
 
 // doGetFallback will attempt to do the request as-is, and on a 405 it will fallback to a GET request.
@@ -482,101 +694,228 @@ func doGetFallback(ctx context.Context, c promapi.Client, u *url.URL, args url.V
 	return c.Do(ctx, req)
 }
 
-func read(ctx context.Context, endpoint *url.URL, labels []prompb.Label, ago, latency time.Duration, m metrics) error {
-	client, err := promapi.NewClient(promapi.Config{Address: endpoint.String()})
+func read(
+	ctx context.Context,
+	rt http.RoundTripper,
+	endpoint *url.URL,
+	w *workload,
+	ago, latency time.Duration,
+	o prometheus.Observer,
+) error {
+	client, err := promapi.NewClient(promapi.Config{Address: endpoint.String(), RoundTripper: rt})
 	if err != nil {
 		return err
 	}
 
-	labelSelectors := make([]string, len(labels))
-	for i, label := range labels {
-		labelSelectors[i] = fmt.Sprintf(`%s="%s"`, label.Name, label.Value)
+	ts := time.Now().Add(ago)
+	generation := w.generation(ts)
+
+	sets, err := w.labelSetsAt(generation)
+	if err != nil {
+		return errors.Wrap(err, "generate series")
+	}
+
+	// Right after a rotation the replacing series may not have been written yet, so only the other series are checked.
+	settled := w.churn == nil || ts.Sub(w.churn.rotatedAt(generation)) >= latency
+	if !settled {
+		if sets = sets[w.churn.rotated(w.series):]; len(sets) == 0 {
+			return nil
+		}
 	}
 
-	query := fmt.Sprintf("{%s}", strings.Join(labelSelectors, ","))
+	if w.typ != seriesTypeGauge {
+		err = readTyped(ctx, client, endpoint, w.typ, sets, ts, latency, o)
+	} else {
+		var vec model.Vector
 
-	q := endpoint.Query()
+		vec, err = instantQuery(ctx, client, endpoint, selector(sets), ts)
+		if err == nil {
+			err = verify(sets, vec, latency, o)
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if w.exemplars {
+		if err := readExemplars(ctx, client, endpoint, w.typ, sets, latency); err != nil {
+			return err
+		}
+	}
+
+	if w.metadata != nil {
+		if err := readMetadata(ctx, client, endpoint, *w.metadata, w.typ, sets); err != nil {
+			return err
+		}
+	}
+
+	if w.churn != nil && settled {
+		return readChurned(ctx, client, endpoint, w, generation, ts)
+	}
+
+	return nil
+}
+
+// instantQuery runs the query at the given time and returns the resulting vector.
+func instantQuery(ctx context.Context, client promapi.Client, endpoint *url.URL, query string, ts time.Time) (model.Vector, error) {
+	// Copy URL to avoid modifying the passed value.
+	u := new(url.URL)
+	*u = *endpoint
+
+	q := u.Query()
 	q.Set("query", query)
 
-	ts := time.Now().Add(ago)
 	if !ts.IsZero() {
 		q.Set("time", formatTime(ts))
 	}
 
-	_, body, err := doGetFallback(ctx, client, endpoint, q) //nolint:bodyclose
+	_, body, err := doGetFallback(ctx, client, u, q) //nolint:bodyclose
 	if err != nil {
-		return errors.Wrap(err, "query request failed")
+		return nil, errors.Wrap(err, "query request failed")
 	}
 
 	var result queryResult
 	if err := json.Unmarshal(body, &result); err != nil {
-		return errors.Wrap(err, "query response parse failed")
+		return nil, errors.Wrap(err, "query response parse failed")
 	}
 
-	vec := result.v.(model.Vector)
-	if len(vec) != 1 {
-		return fmt.Errorf("expected one metric, got %d", len(vec))
+	vec, ok := result.v.(model.Vector)
+	if !ok {
+		return nil, errors.Errorf("query response parse failed: expected vector, got %s", result.Type)
 	}
 
-	t := time.Unix(int64(vec[0].Value/1000), 0)
+	return vec, nil
+}
+
+// verify checks that the vector contains exactly the given series and that their values are recent enough.
+func verify(sets [][]prompb.Label, vec model.Vector, latency time.Duration, o prometheus.Observer) error {
+	if len(vec) != len(sets) {
+		return fmt.Errorf("expected %d metrics, got %d", len(sets), len(vec))
+	}
+
+	found, missing := matchSeries(sets, vec)
+	if len(missing) > 0 {
+		return fmt.Errorf("%d of %d series missing, e.g. %s", len(missing), len(sets), selector(missing[:1]))
+	}
+
+	var maxDiffSeconds float64
+
+	for _, s := range found {
+		t := time.Unix(int64(s.Value/1000), 0)
+
+		diffSeconds := time.Since(t).Seconds()
 
-	diffSeconds := time.Since(t).Seconds()
+		o.Observe(diffSeconds)
 
-	m.metricValueDifference.Observe(diffSeconds)
+		if diffSeconds > maxDiffSeconds {
+			maxDiffSeconds = diffSeconds
+		}
+	}
 
-	if diffSeconds > latency.Seconds() {
-		return fmt.Errorf("metric value is too old: %2.fs", diffSeconds)
+	if maxDiffSeconds > latency.Seconds() {
+		return fmt.Errorf("metric value is too old: %2.fs", maxDiffSeconds)
 	}
 
 	return nil
 }
 
-func write(ctx context.Context, endpoint fmt.Stringer, t TokenProvider, wreq proto.Message, l log.Logger) error {
-	var (
-		buf []byte
-		err error
-		req *http.Request
-		res *http.Response
-	)
-
-	buf, err = proto.Marshal(wreq)
+func write(ctx context.Context, rt http.RoundTripper, endpoint fmt.Stringer, wreq *writeRequest, c compressor, l log.Logger) error {
+	buf, err := wreq.Marshal()
 	if err != nil {
 		return errors.Wrap(err, "marshalling proto")
 	}
 
-	req, err = http.NewRequest("POST", endpoint.String(), bytes.NewBuffer(snappy.Encode(nil, buf)))
+	body, encoding, err := c.compress(buf)
 	if err != nil {
-		return errors.Wrap(err, "creating request")
+		return errors.Wrap(err, "compressing request")
 	}
 
-	token, err := t.Get()
+	_, err = post(ctx, rt, endpoint, body, remoteWriteHeader(remoteWriteContentTypeV1, remoteWriteVersion1, encoding), l)
+
+	return err
+}
+
+// post sends the body to the endpoint and returns a recoverableError for failures worth retrying.
+// On success it returns the headers of the response.
+func post(
+	ctx context.Context,
+	rt http.RoundTripper,
+	endpoint fmt.Stringer,
+	body []byte,
+	header http.Header,
+	l log.Logger,
+) (http.Header, error) {
+	req, err := http.NewRequest("POST", endpoint.String(), bytes.NewBuffer(body))
 	if err != nil {
-		return errors.Wrap(err, "retrieving token")
+		return nil, errors.Wrap(err, "creating request")
 	}
 
-	if token != "" {
-		req.Header.Add("Authorization", "Bearer "+token)
+	for name, vs := range header {
+		req.Header[name] = vs
 	}
 
-	res, err = http.DefaultClient.Do(req.WithContext(ctx)) //nolint:bodyclose
+	res, err := (&http.Client{Transport: rt}).Do(req.WithContext(ctx)) //nolint:bodyclose
 	if err != nil {
-		return errors.Wrap(err, "making request")
+		return nil, recoverableError{error: errors.Wrap(err, "making request")}
 	}
 
 	defer exhaustCloseWithLogOnErr(l, res.Body)
 
-	if res.StatusCode != http.StatusOK {
-		err = errors.New(res.Status)
-		return errors.Wrap(err, "non-200 status")
+	if res.StatusCode/100 != 2 {
+		err = newStatusError(res)
+
+		if res.StatusCode/100 == 5 || res.StatusCode == http.StatusTooManyRequests {
+			return nil, recoverableError{error: err, retryAfter: retryAfter(res.Header.Get("Retry-After"))}
+		}
+
+		return nil, err
+	}
+
+	return res.Header, nil
+}
+
+// evaluate evaluates the results of all components of all tenants against their thresholds.
+func evaluate(l log.Logger, m metrics, opts options) error {
+	var failed []string
+
+	for _, t := range opts.Tenants {
+		tl := t.logger(l)
+
+		if t.WriteEndpoint != nil {
+			if err := reportResults(log.With(tl, "component", "writer"), m.remoteWriteRequests, t.Name, t.SuccessThreshold); err != nil {
+				failed = append(failed, err.Error())
+			}
+		}
+
+		if t.ReadEndpoint != nil && t.WriteEndpoint != nil && !opts.Load.Enabled {
+			if err := reportResults(log.With(tl, "component", "reader"), m.queryResponses, t.Name, t.SuccessThreshold); err != nil {
+				failed = append(failed, err.Error())
+			}
+		}
+
+		if t.ReadEndpoint != nil && opts.Queries != nil {
+			if err := reportQueryResults(log.With(tl, "component", "query-reader"), m, t.Name, opts); err != nil {
+				failed = append(failed, err.Error())
+			}
+		}
+	}
+
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
 	}
 
 	return nil
 }
 
-func reportResults(l log.Logger, c *prometheus.CounterVec, threshold float64) error {
-	metrics := make(chan prometheus.Metric, 2)
-	c.Collect(metrics)
-	close(metrics)
+// reportResults evaluates the success ratio of the tenant's requests counted by the counter against the threshold.
+func reportResults(l log.Logger, c *prometheus.CounterVec, tenant string, threshold float64) error {
+	metrics := make(chan prometheus.Metric)
+
+	go func() {
+		c.Collect(metrics)
+		close(metrics)
+	}()
 
 	var success, errors float64
 
@@ -586,14 +925,27 @@ func reportResults(l log.Logger, c *prometheus.CounterVec, threshold float64) er
 			level.Warn(l).Log("msg", "cannot read success and error count from prometheus counter", "err", err)
 		}
 
+		var t, result string
+
 		for _, l := range m1.Label {
-			switch *l.Value {
-			case "error":
-				errors = m1.GetCounter().GetValue()
-			case "success":
-				success = m1.GetCounter().GetValue()
+			switch l.GetName() {
+			case "tenant":
+				t = l.GetValue()
+			case "result":
+				result = l.GetValue()
 			}
 		}
+
+		if t != tenant {
+			continue
+		}
+
+		switch result {
+		case "error":
+			errors += m1.GetCounter().GetValue()
+		case "success":
+			success += m1.GetCounter().GetValue()
+		}
 	}
 
 	level.Info(l).Log("msg", "number of requests", "success", success, "errors", errors)
@@ -601,33 +953,57 @@ func reportResults(l log.Logger, c *prometheus.CounterVec, threshold float64) er
 	ratio := success / (success + errors)
 	if ratio < threshold {
 		level.Error(l).Log("msg", "ratio is below threshold")
-		return fmt.Errorf("failed with less than %2.f%% success ratio - actual %2.f%%", threshold*100, ratio*100)
+
+		err := fmt.Errorf("failed with less than %2.f%% success ratio - actual %2.f%%", threshold*100, ratio*100)
+		if tenant != "" {
+			err = fmt.Errorf("tenant %q %w", tenant, err)
+		}
+
+		return err
 	}
 
 	return nil
 }
 
-func generate(labels []prompb.Label) *prompb.WriteRequest {
+func generate(sets [][]prompb.Label, w *workload) *writeRequest {
 	timestamp := time.Now().UnixNano() / int64(time.Millisecond)
 
-	return &prompb.WriteRequest{
-		Timeseries: []prompb.TimeSeries{
-			{
-				Labels: labels,
-				Samples: []prompb.Sample{
-					{
-						Value:     float64(timestamp),
-						Timestamp: timestamp,
-					},
-				},
-			},
-		},
+	wreq := &writeRequest{
+		Timeseries: make([]prompb.TimeSeries, 0, len(sets)),
+		Histograms: map[int][]nativeHistogram{},
+		Exemplars:  map[int][]exemplar{},
+	}
+
+	for _, labels := range sets {
+		appendTyped(wreq, w.typ, labels, float64(timestamp), timestamp)
+	}
+
+	if w.exemplars {
+		attachExemplars(wreq, w.typ, sets, newTraceID(), timestamp)
+	}
+
+	if w.metadata != nil {
+		wreq.Metadata = w.metadata.families(w.typ, sets)
 	}
+
+	return wreq
 }
 
 type querySpec struct {
 	Name  string `yaml:"name"`
 	Query string `yaml:"query"`
+	// Interval is the time between runs of the query. Defaults to the period.
+	Interval time.Duration `yaml:"interval"`
+	// Timeout is the time after which a run of the query is cancelled. Defaults to the interval.
+	Timeout time.Duration `yaml:"timeout"`
+	// Jitter is the maximum random delay added to every run of the query, to spread the load of queries with the same interval.
+	Jitter time.Duration `yaml:"jitter"`
+	// Threshold is the ratio of runs of the query that need to succeed for the query to succeed overall.
+	Threshold  *float64    `yaml:"threshold"`
+	LatencySLO *latencySLO `yaml:"latency_slo"`
+	// Range makes the query a range query if set.
+	Range  *rangeSpec   `yaml:"range"`
+	Expect *expectation `yaml:"expect"`
 }
 
 type queriesFile struct {
@@ -636,47 +1012,40 @@ type queriesFile struct {
 
 // Helpers
 func parseFlags(l log.Logger) (options, error) {
-	var (
-		rawWriteEndpoint string
-		rawReadEndpoint  string
-		rawLogLevel      string
-		queriesFileName  string
-		tokenFile        string
-		token            string
-	)
+	cfg, err := parseConfig(os.Args[1:])
+	if err != nil {
+		return options{}, err
+	}
 
-	opts := options{}
-
-	flag.StringVar(&rawLogLevel, "log.level", "info", "The log filtering level. Options: 'error', 'warn', 'info', 'debug'.")
-	flag.StringVar(&rawWriteEndpoint, "endpoint-write", "", "The endpoint to which to make remote-write requests.")
-	flag.StringVar(&rawReadEndpoint, "endpoint-read", "", "The endpoint to which to make query requests.")
-	flag.Var(&opts.Labels, "labels", "The labels in addition to '__name__' that should be applied to remote-write requests.")
-	flag.StringVar(&opts.Listen, "listen", ":8080", "The address on which internal server runs.")
-	flag.StringVar(&opts.Name, "name", "up", "The name of the metric to send in remote-write requests.")
-	flag.StringVar(&token, "token", "",
-		"The bearer token to set in the authorization header on remote-write requests. Takes predence over --token-file if set.")
-	flag.StringVar(&tokenFile, "token-file", "",
-		"The file to read a bearer token from and set in the authorization header on remote-write requests.")
-	flag.StringVar(&queriesFileName, "queries-file", "", "A file containing queries to run against the read endpoint.")
-	flag.DurationVar(&opts.Period, "period", 5*time.Second, "The time to wait between remote-write requests.")
-	flag.DurationVar(&opts.Duration, "duration", 5*time.Minute,
-		"The duration of the up command to run until it stops. If 0 it will not stop until the process is terminated.")
-	flag.Float64Var(&opts.SuccessThreshold, "threshold", 0.9, "The percentage of successful requests needed to succeed overall. 0 - 1.")
-	flag.DurationVar(&opts.Latency, "latency", 15*time.Second, "The maximum allowable latency between writing and reading.")
-	flag.DurationVar(&opts.InitialQueryDelay, "initial-query-delay", 5*time.Second, "The time to wait before executing the first query.")
-	flag.Parse()
-
-	return buildOptionsFromFlags(l, opts, rawLogLevel, rawWriteEndpoint, rawReadEndpoint, queriesFileName, token, tokenFile)
-}
-
-func buildOptionsFromFlags(
-	l log.Logger,
-	opts options,
-	rawLogLevel, rawWriteEndpoint, rawReadEndpoint, queriesFileName, token, tokenFile string,
-) (options, error) {
+	return buildOptions(l, cfg)
+}
+
+func buildOptions(l log.Logger, cfg config) (options, error) {
 	var err error
 
-	switch rawLogLevel {
+	opts := options{
+		EndpointType:      cfg.EndpointType,
+		LogsPushFormat:    cfg.LogsPushFormat,
+		Labels:            cfg.Labels,
+		Listen:            cfg.Listen,
+		Name:              cfg.Name,
+		Series:            cfg.Series,
+		TLS:               cfg.TLS,
+		WriteRetry:        cfg.WriteRetry,
+		WriteCompression:  cfg.WriteCompression,
+		Load:              cfg.Load,
+		Period:            cfg.Period,
+		Duration:          cfg.Duration,
+		Latency:           cfg.Latency,
+		InitialQueryDelay: cfg.InitialQueryDelay,
+		QueryConcurrency:  cfg.QueryConcurrency,
+		QueriesThreshold:  cfg.QueriesThreshold,
+		SuccessThreshold:  cfg.SuccessThreshold,
+		ReloadInterval:    cfg.ReloadInterval,
+		Files:             cfg.files(),
+	}
+
+	switch cfg.LogLevel {
 	case "error":
 		opts.LogLevel = level.AllowError()
 	case "warn":
@@ -686,80 +1055,547 @@ func buildOptionsFromFlags(
 	case "debug":
 		opts.LogLevel = level.AllowDebug()
 	default:
-		panic("unexpected log level")
+		return opts, fmt.Errorf("%s is invalid: unexpected log level %q", cfg.option("log.level", "log_level"), cfg.LogLevel)
+	}
+
+	// Components built from the options log with the configured level as well.
+	l = level.NewFilter(l, opts.LogLevel)
+
+	transport, err := newTransport(log.With(l, "component", "tls"), opts.TLS)
+	if err != nil {
+		return opts, fmt.Errorf("TLS configuration is invalid: %w", err)
+	}
+
+	issuer, err := newTransport(log.With(l, "component", "tls"), opts.TLS.issuer())
+	if err != nil {
+		return opts, fmt.Errorf("TLS configuration is invalid: %w", err)
+	}
+
+	opts.Transports = []http.RoundTripper{transport, issuer}
+
+	opts.Tenants, err = tenants(l, cfg, transport, issuer)
+	if err != nil {
+		return opts, err
+	}
+
+	opts.Queries, err = queries(l, cfg)
+	if err != nil {
+		return opts, err
+	}
+
+	if opts.QueriesThreshold < 0 || opts.QueriesThreshold > 1 {
+		return opts, fmt.Errorf("%s is invalid: %v is not between 0 and 1",
+			cfg.option("queries-threshold", "queries_threshold"), opts.QueriesThreshold)
+	}
+
+	if opts.QueryConcurrency <= 0 {
+		return opts, fmt.Errorf("%s must be positive", cfg.option("query-concurrency", "query_concurrency"))
+	}
+
+	if opts.WriteRetry.MinBackoff <= 0 || opts.WriteRetry.MaxBackoff < opts.WriteRetry.MinBackoff {
+		return opts, fmt.Errorf("%s must be positive and not greater than %s",
+			cfg.option("write-retry-min-backoff", "write_retry.min_backoff"), cfg.option("write-retry-max-backoff", "write_retry.max_backoff"))
+	}
+
+	if opts.Latency <= opts.Period {
+		return opts, fmt.Errorf("%s cannot be less than %s", cfg.option("latency", "latency"), cfg.option("period", "period"))
+	}
+
+	if err := validateEndpointType(cfg, opts); err != nil {
+		return opts, err
+	}
+
+	// Streams pushed to Loki cannot have a metric name, so the name is set as a regular label instead.
+	nameLabel := "__name__"
+	if opts.EndpointType == endpointTypeLogs {
+		nameLabel = logsNameLabel
+	}
+
+	opts.Labels = append(opts.Labels, prompb.Label{
+		Name:  nameLabel,
+		Value: opts.Name,
+	})
+
+	opts.Workload, err = newWorkload(opts.Labels, opts.Series, cfg.SeriesType)
+	if err != nil {
+		return opts, fmt.Errorf("%s or %s is invalid: %w", cfg.option("labels", "labels"), cfg.option("series", "series"), err)
+	}
+
+	if err := validateSeriesType(cfg, opts); err != nil {
+		return opts, err
+	}
+
+	if err := validateMetadata(cfg, opts); err != nil {
+		return opts, err
+	}
+
+	if err := validateWriteCompression(cfg, opts); err != nil {
+		return opts, err
+	}
+
+	if err := validateLoad(cfg, opts); err != nil {
+		return opts, err
+	}
+
+	if err := validateChurn(cfg, opts); err != nil {
+		return opts, err
+	}
+
+	if cfg.Metadata.Enabled {
+		opts.Workload.metadata = &cfg.Metadata
+	}
+
+	opts.Workload.exemplars = cfg.Exemplars
+
+	if cfg.Churn.Fraction > 0 {
+		opts.Workload.churn = &cfg.Churn
+	}
+
+	return opts, err
+}
+
+// validateEndpointType checks that the options are supported for the type of the probed endpoints.
+func validateEndpointType(cfg config, opts options) error {
+	switch opts.EndpointType {
+	case endpointTypeMetrics:
+		return nil
+	case endpointTypeLogs:
+	default:
+		return fmt.Errorf("%s is invalid: unknown type %q", cfg.option("endpoint-type", "endpoint_type"), opts.EndpointType)
+	}
+
+	if opts.LogsPushFormat != logsPushFormatProtobuf && opts.LogsPushFormat != logsPushFormatJSON {
+		return fmt.Errorf("%s is invalid: unknown format %q", cfg.option("logs-push-format", "logs_push_format"), opts.LogsPushFormat)
+	}
+
+	if opts.Queries != nil {
+		return fmt.Errorf("custom queries are not supported with %s=%s", cfg.option("endpoint-type", "endpoint_type"), endpointTypeLogs)
+	}
+
+	for _, t := range opts.Tenants {
+		if t.WriteEndpoint != nil && t.WriteProtocol != writeProtocolRemoteWrite {
+			return fmt.Errorf("write protocol %q is not supported with %s=%s",
+				t.WriteProtocol, cfg.option("endpoint-type", "endpoint_type"), endpointTypeLogs)
+		}
+
+		if t.ReadEndpoint != nil && t.ReadProtocol != readProtocolQuery {
+			return fmt.Errorf("read protocol %q is not supported with %s=%s",
+				t.ReadProtocol, cfg.option("endpoint-type", "endpoint_type"), endpointTypeLogs)
+		}
+	}
+
+	for _, l := range opts.Labels {
+		if l.Name == logsNameLabel {
+			return fmt.Errorf("%s is invalid: the %s label is set to the value of %s",
+				cfg.option("labels", "labels"), logsNameLabel, cfg.option("name", "name"))
+		}
+	}
+
+	return nil
+}
+
+// validateSeriesType checks that the series type can be written and read with the configured endpoints.
+// Only gauges can be written to Loki or through OTLP, and the other types can only be read back through the query API.
+func validateSeriesType(cfg config, opts options) error {
+	option := cfg.option("series-type", "series_type")
+
+	if !validSeriesType(cfg.SeriesType) {
+		return fmt.Errorf("%s is invalid: unknown type %q", option, cfg.SeriesType)
+	}
+
+	if cfg.SeriesType == seriesTypeGauge {
+		return nil
+	}
+
+	if opts.EndpointType != endpointTypeMetrics {
+		return fmt.Errorf("%s=%s requires %s=%s", option, cfg.SeriesType, cfg.option("endpoint-type", "endpoint_type"), endpointTypeMetrics)
+	}
+
+	for _, t := range opts.Tenants {
+		if t.WriteEndpoint != nil && !isRemoteWrite(t.WriteProtocol) {
+			return fmt.Errorf("%s=%s is not supported with write protocol %q", option, cfg.SeriesType, t.WriteProtocol)
+		}
+
+		if t.ReadEndpoint != nil && t.ReadProtocol != readProtocolQuery {
+			return fmt.Errorf("%s=%s is not supported with read protocol %q", option, cfg.SeriesType, t.ReadProtocol)
+		}
+	}
+
+	return nil
+}
+
+// validateMetadata checks that metadata and exemplars can be written and read with the configured endpoints.
+// They are only part of the remote-write protocol and are read back through the APIs next to the query API.
+func validateMetadata(cfg config, opts options) error {
+	var option string
+
+	switch {
+	case cfg.Exemplars && cfg.SeriesType == seriesTypeSummary:
+		return fmt.Errorf("%s is not supported with %s=%s",
+			cfg.option("exemplars", "exemplars"), cfg.option("series-type", "series_type"), cfg.SeriesType)
+	case cfg.Exemplars:
+		option = cfg.option("exemplars", "exemplars")
+	case cfg.Metadata.Enabled:
+		option = cfg.option("metadata", "metadata.enabled")
+	default:
+		return nil
+	}
+
+	if opts.EndpointType != endpointTypeMetrics {
+		return fmt.Errorf("%s requires %s=%s", option, cfg.option("endpoint-type", "endpoint_type"), endpointTypeMetrics)
+	}
+
+	for _, t := range opts.Tenants {
+		if t.WriteEndpoint != nil && !isRemoteWrite(t.WriteProtocol) {
+			return fmt.Errorf("%s is not supported with write protocol %q", option, t.WriteProtocol)
+		}
+
+		if t.ReadEndpoint != nil && t.ReadProtocol != readProtocolQuery {
+			return fmt.Errorf("%s is not supported with read protocol %q", option, t.ReadProtocol)
+		}
+	}
+
+	return nil
+}
+
+// validateWriteCompression checks that the compression is known and, unless it is the default snappy compression,
+// only applied to remote-write requests, as Loki pushes and OTLP requests are encoded on their own.
+func validateWriteCompression(cfg config, opts options) error {
+	option := cfg.option("write-compression", "write_compression")
+
+	if !validCompression(opts.WriteCompression) {
+		return fmt.Errorf("%s is invalid: unknown compression %q", option, opts.WriteCompression)
+	}
+
+	if opts.WriteCompression == compressionSnappy {
+		return nil
+	}
+
+	if opts.EndpointType != endpointTypeMetrics {
+		return fmt.Errorf("%s=%s requires %s=%s",
+			option, opts.WriteCompression, cfg.option("endpoint-type", "endpoint_type"), endpointTypeMetrics)
+	}
+
+	for _, t := range opts.Tenants {
+		if t.WriteEndpoint != nil && !isRemoteWrite(t.WriteProtocol) {
+			return fmt.Errorf("%s=%s is not supported with write protocol %q", option, opts.WriteCompression, t.WriteProtocol)
+		}
+	}
+
+	return nil
+}
+
+// validateChurn checks that churn rotates a label of the written series and that the rotation can be read back.
+// Rotated series are told apart from their replacements by the value of their samples, which only gauges hold directly.
+func validateChurn(cfg config, opts options) error {
+	c := cfg.Churn
+	if c.Fraction == 0 {
+		return nil
+	}
+
+	option := cfg.option("churn-fraction", "churn.fraction")
+
+	switch {
+	case c.Fraction < 0 || c.Fraction > 1:
+		return fmt.Errorf("%s is invalid: must be between 0 and 1", option)
+	case c.Interval <= opts.Latency:
+		return fmt.Errorf("%s is invalid: must be greater than %s",
+			cfg.option("churn-interval", "churn.interval"), cfg.option("latency", "latency"))
+	case opts.EndpointType != endpointTypeMetrics:
+		return fmt.Errorf("%s requires %s=%s", option, cfg.option("endpoint-type", "endpoint_type"), endpointTypeMetrics)
+	case cfg.SeriesType != seriesTypeGauge:
+		return fmt.Errorf("%s requires %s=%s", option, cfg.option("series-type", "series_type"), seriesTypeGauge)
+	case opts.Load.Enabled:
+		return fmt.Errorf("%s cannot be combined with %s, use %s instead",
+			option, cfg.option("load", "load.enabled"), cfg.option("load-churn", "load.churn"))
+	}
+
+	found := false
+
+	for _, l := range opts.Labels {
+		if l.Name == c.Label && l.Name != model.MetricNameLabel {
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		return fmt.Errorf("%s is invalid: %q is not one of %s", cfg.option("churn-label", "churn.label"), c.Label, cfg.option("labels", "labels"))
+	}
+
+	for _, t := range opts.Tenants {
+		if t.ReadEndpoint != nil && t.ReadProtocol != readProtocolQuery {
+			return fmt.Errorf("%s is not supported with read protocol %q", option, t.ReadProtocol)
+		}
+	}
+
+	return nil
+}
+
+// validateLoad checks the options of the load mode.
+// The series of the workload are shared by the writers, so every writer needs at least a full batch of them.
+func validateLoad(cfg config, opts options) error {
+	c := opts.Load
+	if !c.Enabled {
+		return nil
+	}
+
+	option := cfg.option("load", "load.enabled")
+
+	switch {
+	case opts.EndpointType != endpointTypeMetrics:
+		return fmt.Errorf("%s requires %s=%s", option, cfg.option("endpoint-type", "endpoint_type"), endpointTypeMetrics)
+	case len(c.Phases) > 0 && c.SamplesPerSecond > 0:
+		return fmt.Errorf("%s cannot be combined with load.phases in %s",
+			cfg.option("load-samples-per-second", "load.samples_per_second"), cfg.file)
+	case len(c.Phases) > 0 && c.RequestsPerSecond > 0:
+		return fmt.Errorf("%s cannot be combined with load.phases in %s",
+			cfg.option("load-requests-per-second", "load.requests_per_second"), cfg.file)
+	case len(c.Phases) > 0 && opts.Duration != 0 && opts.Duration < c.duration():
+		return fmt.Errorf("%s is invalid: the load phases in %s take %s", cfg.option("duration", "duration"), cfg.file, c.duration())
+	case len(c.Phases) == 0 && (c.SamplesPerSecond > 0) == (c.RequestsPerSecond > 0):
+		return fmt.Errorf("%s requires exactly one of %s and %s to be positive", option,
+			cfg.option("load-samples-per-second", "load.samples_per_second"), cfg.option("load-requests-per-second", "load.requests_per_second"))
+	case c.Writers < 1:
+		return fmt.Errorf("%s is invalid: must be at least 1", cfg.option("load-writers", "load.writers"))
+	case c.BatchSize < 1:
+		return fmt.Errorf("%s is invalid: must be at least 1", cfg.option("load-batch-size", "load.batch_size"))
+	case c.BatchSize*c.Writers > opts.Series:
+		return fmt.Errorf("%s is invalid: %d writers with batches of %d series need at least %d series, got %d",
+			cfg.option("series", "series"), c.Writers, c.BatchSize, c.BatchSize*c.Writers, opts.Series)
+	case c.Churn < 0:
+		return fmt.Errorf("%s is invalid: must not be negative", cfg.option("load-churn", "load.churn"))
+	case c.Churn > 0 && !opts.Workload.templated():
+		return fmt.Errorf("%s requires at least one templated label value, e.g. instance=\"up-{{.Index}}\"",
+			cfg.option("load-churn", "load.churn"))
+	case c.Timeout <= 0:
+		return fmt.Errorf("%s is invalid: must be positive", cfg.option("load-timeout", "load.timeout"))
+	}
+
+	names := map[string]struct{}{}
+
+	for i := range c.Phases {
+		p := &c.Phases[i]
+		if err := p.validate(i); err != nil {
+			return fmt.Errorf("phase %q in load.phases in %s is invalid: %w", p.Name, cfg.file, err)
+		}
+
+		if _, ok := names[p.Name]; ok {
+			return fmt.Errorf("phase %q in load.phases in %s is invalid: name is not unique", p.Name, cfg.file)
+		}
+
+		names[p.Name] = struct{}{}
+	}
+
+	return nil
+}
+
+// tenants returns the tenants to probe: either the ones listed in the tenants file or config file,
+// or a single tenant configured by the endpoint options.
+// OIDC tokens are fetched with the issuer transport.
+func tenants(l log.Logger, cfg config, transport, issuer http.RoundTripper) ([]tenant, error) {
+	specs := cfg.Tenants
+	option := cfg.option("tenants-file", "tenants")
+
+	if cfg.TenantsFile != "" {
+		option = cfg.option("tenants-file", "tenants_file")
+
+		var err error
+		if specs, err = readTenantsFile(cfg.TenantsFile); err != nil {
+			return nil, fmt.Errorf("%s is invalid: %w", option, err)
+		}
+	}
+
+	if err := cfg.Auth.validate(); err != nil {
+		return nil, fmt.Errorf("%s are invalid: %w", cfg.section("authentication flags", "auth"), err)
+	}
+
+	if err := cfg.ReadAuth.validate(); err != nil {
+		return nil, fmt.Errorf("%s are invalid: %w", cfg.section("read authentication flags", "read_auth"), err)
 	}
 
-	if rawWriteEndpoint != "" {
-		writeEndpoint, err := url.ParseRequestURI(rawWriteEndpoint)
+	if specs == nil {
+		t, err := tenantFromOptions(l, cfg, transport, issuer)
 		if err != nil {
-			return opts, fmt.Errorf("--endpoint-write is invalid: %w", err)
+			return nil, err
 		}
 
-		opts.WriteEndpoint = writeEndpoint
+		return []tenant{t}, nil
+	}
+
+	if cfg.WriteEndpoint != "" || cfg.ReadEndpoint != "" || cfg.Tenant != "" {
+		return nil, fmt.Errorf("%s cannot be combined with %s, %s or %s", option,
+			cfg.option("endpoint-write", "endpoint_write"), cfg.option("endpoint-read", "endpoint_read"), cfg.option("tenant", "tenant"))
+	}
+
+	// The tenants inherit the authentication options and the tenant header.
+	defaults := tenantSpec{
+		Header:        cfg.TenantHeader,
+		WriteProtocol: cfg.WriteProtocol,
+		ReadProtocol:  cfg.ReadProtocol,
+		Threshold:     &cfg.SuccessThreshold,
+		Auth:          cfg.Auth,
+		ReadAuth:      cfg.ReadAuth,
+	}
+
+	ts, err := buildTenants(l, specs, defaults, transport, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("%s is invalid: %w", option, err)
+	}
+
+	l.Log("msg", fmt.Sprintf("%d tenants configured to be probed", len(ts)))
+
+	return ts, nil
+}
+
+func tenantFromOptions(l log.Logger, cfg config, transport, issuer http.RoundTripper) (tenant, error) {
+	var writeEndpoint, readEndpoint *url.URL
+
+	if cfg.WriteEndpoint != "" {
+		u, err := url.ParseRequestURI(cfg.WriteEndpoint)
+		if err != nil {
+			return tenant{}, fmt.Errorf("%s is invalid: %w", cfg.option("endpoint-write", "endpoint_write"), err)
+		}
+
+		writeEndpoint = u
+
+		if !validWriteProtocol(cfg.WriteProtocol) {
+			return tenant{}, fmt.Errorf("%s is invalid: unknown protocol %q",
+				cfg.option("endpoint-write-protocol", "endpoint_write_protocol"), cfg.WriteProtocol)
+		}
 	} else {
 		l.Log("msg", "no write endpoint specified, no write tests being performed")
 	}
 
-	if rawReadEndpoint != "" {
-		var readEndpoint *url.URL
-		if rawReadEndpoint != "" {
-			readEndpoint, err = url.ParseRequestURI(rawReadEndpoint)
-			if err != nil {
-				return opts, fmt.Errorf("--endpoint-read is invalid: %w", err)
-			}
+	if cfg.ReadEndpoint != "" {
+		u, err := url.ParseRequestURI(cfg.ReadEndpoint)
+		if err != nil {
+			return tenant{}, fmt.Errorf("%s is invalid: %w", cfg.option("endpoint-read", "endpoint_read"), err)
 		}
 
-		opts.ReadEndpoint = readEndpoint
+		readEndpoint = u
+
+		if cfg.ReadProtocol != readProtocolQuery && cfg.ReadProtocol != readProtocolRemoteRead {
+			return tenant{}, fmt.Errorf("%s is invalid: unknown protocol %q",
+				cfg.option("endpoint-read-protocol", "endpoint_read_protocol"), cfg.ReadProtocol)
+		}
 	} else {
 		l.Log("msg", "no read endpoint specified, no read tests being performed")
 	}
 
-	if queriesFileName != "" {
-		b, err := ioutil.ReadFile(queriesFileName)
+	if cfg.TenantHeader != "" && cfg.Tenant == "" {
+		return tenant{}, fmt.Errorf("%s is required when setting %s",
+			cfg.option("tenant", "tenant"), cfg.option("tenant-header", "tenant_header"))
+	}
+
+	if cfg.SuccessThreshold < 0 || cfg.SuccessThreshold > 1 {
+		return tenant{}, fmt.Errorf("%s is invalid: %v is not between 0 and 1", cfg.option("threshold", "threshold"), cfg.SuccessThreshold)
+	}
+
+	spec := tenantSpec{
+		Name:          cfg.Tenant,
+		Header:        cfg.TenantHeader,
+		WriteProtocol: cfg.WriteProtocol,
+		ReadProtocol:  cfg.ReadProtocol,
+		Threshold:     &cfg.SuccessThreshold,
+		Auth:          cfg.Auth,
+		ReadAuth:      cfg.ReadAuth,
+	}
+
+	return spec.build(log.With(l, "component", "token"), writeEndpoint, readEndpoint, transport, issuer), nil
+}
+
+// queries returns the custom queries, read from the queries file if set.
+func queries(l log.Logger, cfg config) ([]querySpec, error) {
+	qs := cfg.Queries
+	option := cfg.option("queries-file", "queries")
+
+	if cfg.QueriesFile != "" {
+		option = cfg.option("queries-file", "queries_file")
+
+		b, err := ioutil.ReadFile(cfg.QueriesFile)
 		if err != nil {
-			return opts, fmt.Errorf("--queries-file is invalid: %w", err)
+			return nil, fmt.Errorf("%s is invalid: %w", option, err)
 		}
 
 		qf := queriesFile{}
 		err = yaml.Unmarshal(b, &qf)
 
 		if err != nil {
-			return opts, fmt.Errorf("--queries-file content is invalid: %w", err)
+			return nil, fmt.Errorf("%s content is invalid: %w", option, err)
 		}
 
-		l.Log("msg", fmt.Sprintf("%d queries configured to be queried periodically", len(qf.Queries)))
+		qs = qf.Queries
+	}
 
-		// validate queries
-		for _, q := range qf.Queries {
-			_, err = parser.ParseExpr(q.Query)
-			if err != nil {
-				return opts, fmt.Errorf("query %q in --queries-file content is invalid: %w", q.Name, err)
-			}
+	if qs == nil {
+		return nil, nil
+	}
+
+	l.Log("msg", fmt.Sprintf("%d queries configured to be queried periodically", len(qs)))
+
+	names := map[string]struct{}{}
+
+	// validate queries
+	for i, q := range qs {
+		_, err := parser.ParseExpr(q.Query)
+		if err != nil {
+			return nil, fmt.Errorf("query %q in %s is invalid: %w", q.Name, option, err)
 		}
 
-		opts.Queries = qf.Queries
-	}
+		if _, ok := names[q.Name]; ok {
+			return nil, fmt.Errorf("query %q in %s is invalid: name is not unique", q.Name, option)
+		}
 
-	if opts.Latency <= opts.Period {
-		return opts, errors.New("--latency cannot be less than period")
-	}
+		names[q.Name] = struct{}{}
 
-	opts.Labels = append(opts.Labels, prompb.Label{
-		Name:  "__name__",
-		Value: opts.Name,
-	})
+		if q.Interval == 0 {
+			qs[i].Interval = cfg.Period
+		}
 
-	opts.Token = tokenProvider(token, tokenFile)
+		if q.Timeout == 0 {
+			qs[i].Timeout = qs[i].Interval
+		}
 
-	return opts, err
+		if q.LatencySLO != nil && q.LatencySLO.Threshold == 0 {
+			q.LatencySLO.Threshold = 1
+		}
+
+		if err := q.validateThresholds(); err != nil {
+			return nil, fmt.Errorf("query %q in %s is invalid: %w", q.Name, option, err)
+		}
+
+		if qs[i].Interval < 0 || qs[i].Timeout < 0 || qs[i].Timeout > qs[i].Interval || q.Jitter < 0 {
+			return nil, fmt.Errorf("query %q in %s is invalid: interval, timeout and jitter must be positive "+
+				"and timeout cannot be greater than interval", q.Name, option)
+		}
+
+		if q.Range != nil {
+			if err := q.Range.validate(); err != nil {
+				return nil, fmt.Errorf("range of query %q in %s is invalid: %w", q.Name, option, err)
+			}
+		}
+
+		if q.Expect != nil {
+			if err := q.Expect.validate(); err != nil {
+				return nil, fmt.Errorf("expectation of query %q in %s is invalid: %w", q.Name, option, err)
+			}
+		}
+	}
+
+	return qs, nil
 }
 
-func tokenProvider(token, tokenFile string) TokenProvider {
+func tokenProvider(l log.Logger, token, tokenFile string, oidc oidcConfig, issuer http.RoundTripper) TokenProvider {
 	var res TokenProvider
 
 	res = NewNoOpTokenProvider()
+	if oidc.enabled() {
+		res = NewOIDCToken(oidc, issuer)
+	}
+
 	if tokenFile != "" {
-		res = NewFileToken(tokenFile)
+		res = NewFileToken(l, tokenFile)
 	}
 
 	if token != "" {
@@ -769,43 +1605,165 @@ func tokenProvider(token, tokenFile string) TokenProvider {
 	return res
 }
 
+// registerCollectors registers the collectors, skipping the ones shared by several components.
+func registerCollectors(reg *prometheus.Registry, cs ...prometheus.Collector) {
+	for _, c := range cs {
+		if err := reg.Register(c); err != nil {
+			if _, ok := err.(prometheus.AlreadyRegisteredError); !ok {
+				panic(err)
+			}
+		}
+	}
+}
+
 func registerMetrics(reg *prometheus.Registry) metrics {
 	m := metrics{
 		remoteWriteRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Name: "up_remote_writes_total",
-			Help: "Total number of remote write requests.",
-		}, []string{"result"}),
+			Help: "Total number of remote write requests by result and class of the response.",
+		}, []string{"tenant", "result", "class"}),
+		remoteWriteRetries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "up_remote_write_retries_total",
+			Help: "Total number of retried remote write requests.",
+		}, []string{"tenant"}),
+		remoteWriteRecovered: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "up_remote_writes_recovered_total",
+			Help: "Total number of remote write requests that succeeded after being retried.",
+		}, []string{"tenant"}),
+		remoteWriteDropped: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "up_remote_writes_dropped_total",
+			Help: "Total number of remote write requests that were given up on after running out of retries.",
+		}, []string{"tenant"}),
+		remoteWriteUncompressedBytes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "up_remote_write_request_uncompressed_bytes",
+			Help:    "The size of remote write requests before compression.",
+			Buckets: prometheus.ExponentialBuckets(64, 4, 10),
+		}, []string{"tenant", "compression"}),
+		remoteWriteCompressedBytes: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "up_remote_write_request_compressed_bytes",
+			Help:    "The size of remote write requests after compression, as sent to the write endpoint.",
+			Buckets: prometheus.ExponentialBuckets(64, 4, 10),
+		}, []string{"tenant", "compression"}),
+		remoteWriteV2Fallbacks: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "up_remote_write_v2_fallbacks_total",
+			Help: "Total number of remote-write 2.0 requests rejected as unsupported and sent again as remote-write 1.0.",
+		}, []string{"tenant"}),
+		churnedSeries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "up_churned_series_total",
+			Help: "Total number of series replaced by new series under churn.",
+		}, []string{"tenant"}),
+		loadRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "up_load_request_duration_seconds",
+			Help:    "The duration of remote write requests in load mode, including retries.",
+			Buckets: loadLatencyBuckets,
+		}, []string{"tenant"}),
+		loadSamples: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "up_load_samples_total",
+			Help: "Total number of samples written in load mode by result.",
+		}, []string{"tenant", "result"}),
+		loadMissedRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "up_load_missed_requests_total",
+			Help: "Total number of requests in load mode that were not sent because all writers were busy.",
+		}, []string{"tenant"}),
+		loadTargetRequestRate: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "up_load_target_requests_per_second",
+			Help: "The target number of requests per second in load mode.",
+		}, []string{"tenant"}),
 		queryResponses: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Name: "up_queries_total",
 			Help: "The total number of queries made.",
-		}, []string{"result"}),
-		metricValueDifference: prometheus.NewHistogram(prometheus.HistogramOpts{
+		}, []string{"tenant", "result"}),
+		metricValueDifference: prometheus.NewHistogramVec(prometheus.HistogramOpts{
 			Name:    "up_metric_value_difference",
 			Help:    "The time difference between the current timestamp and the timestamp in the metrics value.",
 			Buckets: prometheus.LinearBuckets(4, 0.25, 16),
-		}),
+		}, []string{"tenant"}),
 		customQueryExecuted: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Name: "up_custom_query_executed_total",
 			Help: "The total number of custom specified queries executed.",
-		}, []string{"query"}),
+		}, []string{"tenant", "query"}),
 		customQueryErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
 			Name: "up_custom_query_errors_total",
 			Help: "The total number of custom specified queries executed.",
-		}, []string{"query"}),
+		}, []string{"tenant", "query"}),
 		customQueryLastDuration: prometheus.NewGaugeVec(prometheus.GaugeOpts{
 			Name: "up_custom_query_last_duration",
 			Help: "The duration of the query execution last time the query was executed successfully.",
-		}, []string{"query"}),
+		}, []string{"tenant", "query"}),
+		customQuerySlow: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "up_custom_query_slow_total",
+			Help: "The total number of custom specified queries that took longer than the target of their latency SLO.",
+		}, []string{"tenant", "query"}),
+		customQueryWarnings: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "up_custom_query_warnings_total",
+			Help: "The total number of warnings returned by the API for custom specified queries.",
+		}, []string{"tenant", "query"}),
+		customQueryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "up_custom_query_duration_seconds",
+			Help:    "The duration of custom specified query executions by outcome.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"tenant", "query", "outcome"}),
+		customQuerySeries: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "up_custom_query_series",
+			Help:    "The number of series returned by successfully executed custom specified queries.",
+			Buckets: prometheus.ExponentialBuckets(1, 4, 10),
+		}, []string{"tenant", "query"}),
+		customQuerySamples: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "up_custom_query_samples",
+			Help:    "The number of samples returned by successfully executed custom specified queries.",
+			Buckets: prometheus.ExponentialBuckets(1, 4, 12),
+		}, []string{"tenant", "query"}),
+		customQueryLastSeries: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "up_custom_query_last_series",
+			Help: "The number of series returned by the query the last time the query was executed successfully.",
+		}, []string{"tenant", "query"}),
+		customQueryLastSamples: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "up_custom_query_last_samples",
+			Help: "The number of samples returned by the query the last time the query was executed successfully.",
+		}, []string{"tenant", "query"}),
+		customQueryAssertionFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "up_custom_query_assertion_failures_total",
+			Help: "The total number of custom specified queries whose results did not meet their expectation.",
+		}, []string{"tenant", "query"}),
+		configReloads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "up_config_reloads_total",
+			Help: "Total number of configuration reloads by result.",
+		}, []string{"result"}),
+		configLastReloadSuccessful: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "up_config_last_reload_successful",
+			Help: "Whether the last configuration reload attempt was successful.",
+		}),
 	}
 	reg.MustRegister(
 		prometheus.NewGoCollector(),
 		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
 		m.remoteWriteRequests,
+		m.remoteWriteRetries,
+		m.remoteWriteRecovered,
+		m.remoteWriteDropped,
+		m.remoteWriteUncompressedBytes,
+		m.remoteWriteCompressedBytes,
+		m.remoteWriteV2Fallbacks,
+		m.churnedSeries,
+		m.loadRequestDuration,
+		m.loadSamples,
+		m.loadMissedRequests,
+		m.loadTargetRequestRate,
 		m.queryResponses,
 		m.metricValueDifference,
 		m.customQueryExecuted,
 		m.customQueryErrors,
 		m.customQueryLastDuration,
+		m.customQuerySlow,
+		m.customQueryWarnings,
+		m.customQueryDuration,
+		m.customQuerySeries,
+		m.customQuerySamples,
+		m.customQueryLastSeries,
+		m.customQueryLastSamples,
+		m.customQueryAssertionFailures,
+		m.configReloads,
+		m.configLastReloadSuccessful,
 	)
 
 	return m
@@ -821,6 +1779,86 @@ func exhaustCloseWithLogOnErr(l log.Logger, rc io.ReadCloser) {
 	}
 }
 
+// reportQueryResults evaluates the success ratio of the tenant's custom queries against the aggregate threshold,
+// as well as the success ratio and latency of every query against its own thresholds.
+func reportQueryResults(l log.Logger, m metrics, tenant string, opts options) error {
+	var (
+		failed                  []string
+		totalExecuted, totalBad float64
+	)
+
+	for _, q := range opts.Queries {
+		var (
+			executed = counterValue(m.customQueryExecuted.WithLabelValues(tenant, q.Name))
+			errs     = counterValue(m.customQueryErrors.WithLabelValues(tenant, q.Name))
+			failures = counterValue(m.customQueryAssertionFailures.WithLabelValues(tenant, q.Name))
+			slow     = counterValue(m.customQuerySlow.WithLabelValues(tenant, q.Name))
+		)
+
+		level.Info(l).Log("msg", "number of query runs", "name", q.Name, "executed", executed, "errors", errs, "assertion_failures", failures)
+
+		totalExecuted += executed
+		totalBad += errs + failures
+
+		// A query that never ran cannot meet its thresholds.
+		if executed == 0 && ((q.Threshold != nil && *q.Threshold > 0) || q.LatencySLO != nil) {
+			failed = append(failed, fmt.Sprintf("query %q was not executed", q.Name))
+			continue
+		}
+
+		if ratio := successRatio(executed, errs+failures); q.Threshold != nil && ratio < *q.Threshold {
+			failed = append(failed, fmt.Sprintf("query %q failed with less than %2.f%% success ratio - actual %2.f%%",
+				q.Name, *q.Threshold*100, ratio*100))
+		}
+
+		if ratio := successRatio(executed-errs, slow); q.LatencySLO != nil && ratio < q.LatencySLO.Threshold {
+			failed = append(failed, fmt.Sprintf("query %q finished within %s in less than %2.f%% of runs - actual %2.f%%",
+				q.Name, q.LatencySLO.Target, q.LatencySLO.Threshold*100, ratio*100))
+		}
+	}
+
+	switch ratio := successRatio(totalExecuted, totalBad); {
+	case len(opts.Queries) > 0 && totalExecuted == 0 && opts.QueriesThreshold > 0:
+		failed = append(failed, "no queries were executed")
+	case ratio < opts.QueriesThreshold:
+		failed = append(failed, fmt.Sprintf("queries failed with less than %2.f%% success ratio - actual %2.f%%",
+			opts.QueriesThreshold*100, ratio*100))
+	}
+
+	if len(failed) == 0 {
+		return nil
+	}
+
+	for _, f := range failed {
+		level.Error(l).Log("msg", f)
+	}
+
+	err := errors.New(strings.Join(failed, ", "))
+	if tenant != "" {
+		err = fmt.Errorf("tenant %q %w", tenant, err)
+	}
+
+	return err
+}
+
+// successRatio returns the ratio of the runs that were not bad, which is 0 without any runs.
+func successRatio(runs, bad float64) float64 {
+	if runs == 0 {
+		return 0
+	}
+
+	return (runs - bad) / runs
+}
+
+func counterValue(c prometheus.Counter) float64 {
+	m := &dto.Metric{}
+	if err := c.Write(m); err != nil {
+		return 0
+	}
+
+	return m.GetCounter().GetValue()
+}
+
 func formatTime(t time.Time) string {
 	return strconv.FormatFloat(float64(t.Unix())+float64(t.Nanosecond())/1e9, 'f', -1, 64)
 }
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestReportQueryResults(t *testing.T) {
+	var (
+		threshold = 0.5
+		slo       = &latencySLO{Target: time.Second, Threshold: 0.5}
+	)
+
+	type runs struct {
+		executed, errors, failures, slow float64
+	}
+
+	for _, tc := range []struct {
+		name             string
+		queries          []querySpec
+		runs             []runs
+		queriesThreshold float64
+		failed           bool
+	}{
+		{
+			name:             "all succeeded",
+			queries:          []querySpec{{Name: "a", Threshold: &threshold, LatencySLO: slo}},
+			runs:             []runs{{executed: 2}},
+			queriesThreshold: 0.9,
+		},
+		{
+			name:             "below queries threshold",
+			queries:          []querySpec{{Name: "a"}, {Name: "b"}},
+			runs:             []runs{{executed: 2}, {executed: 2, errors: 1}},
+			queriesThreshold: 0.9,
+			failed:           true,
+		},
+		{
+			name:             "assertion failures below query threshold",
+			queries:          []querySpec{{Name: "a", Threshold: &threshold}},
+			runs:             []runs{{executed: 4, errors: 1, failures: 2}},
+			queriesThreshold: 0,
+			failed:           true,
+		},
+		{
+			name:             "slow runs below latency SLO",
+			queries:          []querySpec{{Name: "a", LatencySLO: slo}},
+			runs:             []runs{{executed: 4, errors: 1, slow: 2}},
+			queriesThreshold: 0,
+			failed:           true,
+		},
+		{
+			name:             "not executed with queries threshold",
+			queries:          []querySpec{{Name: "a"}},
+			runs:             []runs{{}},
+			queriesThreshold: 0.9,
+			failed:           true,
+		},
+		{
+			name:             "not executed with query threshold",
+			queries:          []querySpec{{Name: "a", Threshold: &threshold}, {Name: "b"}},
+			runs:             []runs{{}, {executed: 2}},
+			queriesThreshold: 0,
+			failed:           true,
+		},
+		{
+			name:             "not executed with latency SLO",
+			queries:          []querySpec{{Name: "a", LatencySLO: slo}, {Name: "b"}},
+			runs:             []runs{{}, {executed: 2}},
+			queriesThreshold: 0,
+			failed:           true,
+		},
+		{
+			name:             "not executed without thresholds",
+			queries:          []querySpec{{Name: "a"}},
+			runs:             []runs{{}},
+			queriesThreshold: 0,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := registerMetrics(prometheus.NewRegistry())
+
+			for i, q := range tc.queries {
+				r := tc.runs[i]
+				m.customQueryExecuted.WithLabelValues("", q.Name).Add(r.executed)
+				m.customQueryErrors.WithLabelValues("", q.Name).Add(r.errors)
+				m.customQueryAssertionFailures.WithLabelValues("", q.Name).Add(r.failures)
+				m.customQuerySlow.WithLabelValues("", q.Name).Add(r.slow)
+			}
+
+			err := reportQueryResults(log.NewNopLogger(), m, "", options{Queries: tc.queries, QueriesThreshold: tc.queriesThreshold})
+			if tc.failed && err == nil {
+				t.Error("expected the queries to fail")
+			}
+
+			if !tc.failed && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/url"
+	"path"
+
+	"github.com/pkg/errors"
+	promapi "github.com/prometheus/client_golang/api"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// metadataConfig configures the metadata sent along with the written series.
+type metadataConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Help    string `yaml:"help"`
+	Unit    string `yaml:"unit"`
+}
+
+// register registers the flags configuring metadata.
+func (c *metadataConfig) register(fs *flag.FlagSet) {
+	fs.BoolVar(&c.Enabled, "metadata", false,
+		"Send the TYPE, HELP and UNIT metadata of the written metrics in remote-write requests and check it against "+
+			"the /api/v1/metadata endpoint next to the read endpoint. The type follows --series-type.")
+	fs.StringVar(&c.Help, "metadata-help", "Heartbeat written by up, holding the time it was written at in milliseconds.",
+		"The help text sent as metadata of the written metrics.")
+	fs.StringVar(&c.Unit, "metadata-unit", "", "The unit sent as metadata of the written metrics, e.g. 'milliseconds'.")
+}
+
+// metricMetadata is the metadata of a metric family, as sent in remote-write requests.
+type metricMetadata struct {
+	// Type is the value of the MetricType enum of remote-write, e.g. 2 for gauges.
+	Type             int
+	MetricFamilyName string
+	Help             string
+	Unit             string
+}
+
+// fields returns the protobuf fields of the metadata:
+//
+//	message MetricMetadata { MetricType type = 1; string metric_family_name = 2; string help = 4; string unit = 5; }
+func (m metricMetadata) fields() []protoField {
+	return []protoField{
+		varintField(1, uint64(m.Type)),
+		stringField(2, m.MetricFamilyName),
+		stringField(4, m.Help),
+		stringField(5, m.Unit),
+	}
+}
+
+// metadataType returns the MetricType enum value of remote-write and the type returned by the metadata API for a series type.
+func metadataType(typ string) (int, string) {
+	switch typ {
+	case seriesTypeHistogram, seriesTypeNativeHistogram:
+		return 3, "histogram"
+	case seriesTypeSummary:
+		return 5, "summary"
+	default:
+		return 2, "gauge"
+	}
+}
+
+// families returns the metadata of every metric family written for the label sets.
+func (c metadataConfig) families(typ string, sets [][]prompb.Label) []metricMetadata {
+	var (
+		t, _ = metadataType(typ)
+		ms   []metricMetadata
+		seen = map[string]struct{}{}
+	)
+
+	for _, lset := range sets {
+		name := metricName(lset)
+		if _, ok := seen[name]; ok {
+			continue
+		}
+
+		seen[name] = struct{}{}
+		ms = append(ms, metricMetadata{Type: t, MetricFamilyName: name, Help: c.Help, Unit: c.Unit})
+	}
+
+	return ms
+}
+
+// apiEndpoint returns the endpoint of the API with the given name next to the read endpoint,
+// e.g. /api/v1/metadata for /api/v1/query.
+func apiEndpoint(endpoint *url.URL, name string) *url.URL {
+	// Copy URL to avoid modifying the passed value.
+	u := new(url.URL)
+	*u = *endpoint
+	u.Path = path.Join(path.Dir(u.Path), name)
+
+	return u
+}
+
+// readMetadata checks that the metadata API returns the metadata written for every metric family.
+func readMetadata(
+	ctx context.Context,
+	client promapi.Client,
+	endpoint *url.URL,
+	c metadataConfig,
+	typ string,
+	sets [][]prompb.Label,
+) error {
+	_, expectedType := metadataType(typ)
+
+	for _, m := range c.families(typ, sets) {
+		u := apiEndpoint(endpoint, "metadata")
+
+		q := u.Query()
+		q.Set("metric", m.MetricFamilyName)
+
+		_, body, err := doGetFallback(ctx, client, u, q) //nolint:bodyclose
+		if err != nil {
+			return errors.Wrap(err, "metadata request failed")
+		}
+
+		var result struct {
+			Status string `json:"status"`
+			Data   map[string][]struct {
+				Type string `json:"type"`
+				Help string `json:"help"`
+				Unit string `json:"unit"`
+			} `json:"data"`
+		}
+
+		if err := json.Unmarshal(body, &result); err != nil {
+			return errors.Wrap(err, "metadata response parse failed")
+		}
+
+		entries := result.Data[m.MetricFamilyName]
+		if len(entries) == 0 {
+			return fmt.Errorf("metadata of %s missing", m.MetricFamilyName)
+		}
+
+		found := false
+
+		for _, e := range entries {
+			if e.Type == expectedType && e.Help == m.Help && e.Unit == m.Unit {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return fmt.Errorf("expected metadata type=%q help=%q unit=%q of %s, got %+v", expectedType, m.Help, m.Unit, m.MetricFamilyName, entries)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gogo/protobuf/proto"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+const (
+	// writeProtocolRemoteWrite writes the series through the Prometheus remote-write protocol.
+	writeProtocolRemoteWrite = "remote-write"
+	// writeProtocolRemoteWriteV2 writes the series through version 2.0 of the Prometheus remote-write protocol.
+	writeProtocolRemoteWriteV2 = "remote-write-v2"
+	// writeProtocolOTLPProtobuf writes the series as OTLP/HTTP metrics encoded as protobuf.
+	writeProtocolOTLPProtobuf = "otlp-protobuf"
+	// writeProtocolOTLPJSON writes the series as OTLP/HTTP metrics encoded as JSON.
+	writeProtocolOTLPJSON = "otlp-json"
+
+	// otlpScopeName is the name of the instrumentation scope of the written metrics.
+	otlpScopeName = "up"
+)
+
+func validWriteProtocol(protocol string) bool {
+	switch protocol {
+	case writeProtocolRemoteWrite, writeProtocolRemoteWriteV2, writeProtocolOTLPProtobuf, writeProtocolOTLPJSON:
+		return true
+	default:
+		return false
+	}
+}
+
+// otlpMetricsRequest mirrors the ExportMetricsServiceRequest of OTLP, limited to gauges with string attributes.
+// The JSON tags follow the OTLP/HTTP JSON encoding. The protobuf encoding is done by hand,
+// as the OTLP protobuf definitions are not available as a dependency.
+type otlpMetricsRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpMetric struct {
+	Name  string    `json:"name"`
+	Gauge otlpGauge `json:"gauge"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpNumberDataPoint `json:"dataPoints"`
+}
+
+type otlpNumberDataPoint struct {
+	Attributes   []otlpKeyValue `json:"attributes"`
+	TimeUnixNano uint64         `json:"timeUnixNano,string"`
+	AsDouble     float64        `json:"asDouble"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+// generateOTLP returns a request writing the same samples as generate, with a gauge for every metric name
+// and a data point for every series. The labels of the series other than the metric name become attributes of the data points.
+func generateOTLP(sets [][]prompb.Label) otlpMetricsRequest {
+	ts := time.Now()
+	timestamp := ts.UnixNano() / int64(time.Millisecond)
+
+	var (
+		metrics []otlpMetric
+		byName  = map[string]int{}
+	)
+
+	for _, lset := range sets {
+		var (
+			name  string
+			attrs = make([]otlpKeyValue, 0, len(lset))
+		)
+
+		for _, l := range lset {
+			if l.Name == "__name__" {
+				name = l.Value
+				continue
+			}
+
+			attrs = append(attrs, otlpKeyValue{Key: l.Name, Value: otlpAnyValue{StringValue: l.Value}})
+		}
+
+		i, ok := byName[name]
+		if !ok {
+			i = len(metrics)
+			byName[name] = i
+			metrics = append(metrics, otlpMetric{Name: name})
+		}
+
+		metrics[i].Gauge.DataPoints = append(metrics[i].Gauge.DataPoints, otlpNumberDataPoint{
+			Attributes:   attrs,
+			TimeUnixNano: uint64(ts.UnixNano()),
+			AsDouble:     float64(timestamp),
+		})
+	}
+
+	return otlpMetricsRequest{
+		ResourceMetrics: []otlpResourceMetrics{{
+			Resource: otlpResource{Attributes: []otlpKeyValue{}},
+			ScopeMetrics: []otlpScopeMetrics{{
+				Scope:   otlpScope{Name: otlpScopeName},
+				Metrics: metrics,
+			}},
+		}},
+	}
+}
+
+// encode encodes the request as the given OTLP write protocol and returns the body and its content type.
+func (r otlpMetricsRequest) encode(protocol string) ([]byte, string, error) {
+	if protocol == writeProtocolOTLPJSON {
+		b, err := json.Marshal(r)
+		return b, "application/json", err
+	}
+
+	b := proto.NewBuffer(nil)
+	if err := encodeFields(b, r.fields()...); err != nil {
+		return nil, "", err
+	}
+
+	return b.Bytes(), "application/x-protobuf", nil
+}
+
+// fields returns the protobuf fields of the request:
+//
+//	message ExportMetricsServiceRequest { repeated ResourceMetrics resource_metrics = 1; }
+//	message ResourceMetrics { Resource resource = 1; repeated ScopeMetrics scope_metrics = 2; }
+//	message Resource { repeated KeyValue attributes = 1; }
+//	message ScopeMetrics { InstrumentationScope scope = 1; repeated Metric metrics = 2; }
+//	message InstrumentationScope { string name = 1; }
+//	message Metric { string name = 1; Gauge gauge = 5; }
+//	message Gauge { repeated NumberDataPoint data_points = 1; }
+//	message NumberDataPoint { repeated KeyValue attributes = 7; fixed64 time_unix_nano = 3; double as_double = 4; }
+//	message KeyValue { string key = 1; AnyValue value = 2; }
+//	message AnyValue { string string_value = 1; }
+func (r otlpMetricsRequest) fields() []protoField {
+	var fs []protoField
+
+	for _, rm := range r.ResourceMetrics {
+		var rfs []protoField
+
+		rfs = append(rfs, messageField(1, attributeFields(1, rm.Resource.Attributes)...))
+
+		for _, sm := range rm.ScopeMetrics {
+			sfs := []protoField{messageField(1, stringField(1, sm.Scope.Name))}
+
+			for _, m := range sm.Metrics {
+				var dps []protoField
+
+				for _, dp := range m.Gauge.DataPoints {
+					dfs := attributeFields(7, dp.Attributes)
+					dfs = append(dfs, fixed64Field(3, dp.TimeUnixNano), doubleField(4, dp.AsDouble))
+					dps = append(dps, messageField(1, dfs...))
+				}
+
+				sfs = append(sfs, messageField(2, stringField(1, m.Name), messageField(5, dps...)))
+			}
+
+			rfs = append(rfs, messageField(2, sfs...))
+		}
+
+		fs = append(fs, messageField(1, rfs...))
+	}
+
+	return fs
+}
+
+func attributeFields(num int, attrs []otlpKeyValue) []protoField {
+	fs := make([]protoField, len(attrs))
+	for i, a := range attrs {
+		fs[i] = messageField(num, stringField(1, a.Key), messageField(2, stringField(1, a.Value.StringValue)))
+	}
+
+	return fs
+}
+
+// writeOTLP sends the request to the OTLP/HTTP metrics endpoint in the format of the given write protocol.
+func writeOTLP(
+	ctx context.Context,
+	rt http.RoundTripper,
+	endpoint fmt.Stringer,
+	protocol string,
+	req otlpMetricsRequest,
+	l log.Logger,
+) error {
+	body, contentType, err := req.encode(protocol)
+	if err != nil {
+		return errors.Wrap(err, "encoding OTLP request")
+	}
+
+	_, err = post(ctx, rt, endpoint, body, http.Header{"Content-Type": []string{contentType}}, l)
+
+	return err
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// The messages of opentelemetry.proto.collector.metrics.v1.ExportMetricsServiceRequest used to decode the protobuf encoding,
+// limited to the fields written by UP.
+type otlpPBRequest struct {
+	ResourceMetrics []*otlpPBResourceMetrics `protobuf:"bytes,1,rep,name=resource_metrics,proto3"`
+}
+
+func (m *otlpPBRequest) Reset()         { *m = otlpPBRequest{} }
+func (m *otlpPBRequest) String() string { return proto.CompactTextString(m) }
+func (*otlpPBRequest) ProtoMessage()    {}
+
+type otlpPBResourceMetrics struct {
+	Resource     *otlpPBResource       `protobuf:"bytes,1,opt,name=resource,proto3"`
+	ScopeMetrics []*otlpPBScopeMetrics `protobuf:"bytes,2,rep,name=scope_metrics,proto3"`
+}
+
+func (m *otlpPBResourceMetrics) Reset()         { *m = otlpPBResourceMetrics{} }
+func (m *otlpPBResourceMetrics) String() string { return proto.CompactTextString(m) }
+func (*otlpPBResourceMetrics) ProtoMessage()    {}
+
+type otlpPBResource struct {
+	Attributes []*otlpPBKeyValue `protobuf:"bytes,1,rep,name=attributes,proto3"`
+}
+
+func (m *otlpPBResource) Reset()         { *m = otlpPBResource{} }
+func (m *otlpPBResource) String() string { return proto.CompactTextString(m) }
+func (*otlpPBResource) ProtoMessage()    {}
+
+type otlpPBScopeMetrics struct {
+	Scope   *otlpPBScope    `protobuf:"bytes,1,opt,name=scope,proto3"`
+	Metrics []*otlpPBMetric `protobuf:"bytes,2,rep,name=metrics,proto3"`
+}
+
+func (m *otlpPBScopeMetrics) Reset()         { *m = otlpPBScopeMetrics{} }
+func (m *otlpPBScopeMetrics) String() string { return proto.CompactTextString(m) }
+func (*otlpPBScopeMetrics) ProtoMessage()    {}
+
+type otlpPBScope struct {
+	Name string `protobuf:"bytes,1,opt,name=name,proto3"`
+}
+
+func (m *otlpPBScope) Reset()         { *m = otlpPBScope{} }
+func (m *otlpPBScope) String() string { return proto.CompactTextString(m) }
+func (*otlpPBScope) ProtoMessage()    {}
+
+type otlpPBMetric struct {
+	Name  string       `protobuf:"bytes,1,opt,name=name,proto3"`
+	Gauge *otlpPBGauge `protobuf:"bytes,5,opt,name=gauge,proto3"`
+}
+
+func (m *otlpPBMetric) Reset()         { *m = otlpPBMetric{} }
+func (m *otlpPBMetric) String() string { return proto.CompactTextString(m) }
+func (*otlpPBMetric) ProtoMessage()    {}
+
+type otlpPBGauge struct {
+	DataPoints []*otlpPBDataPoint `protobuf:"bytes,1,rep,name=data_points,proto3"`
+}
+
+func (m *otlpPBGauge) Reset()         { *m = otlpPBGauge{} }
+func (m *otlpPBGauge) String() string { return proto.CompactTextString(m) }
+func (*otlpPBGauge) ProtoMessage()    {}
+
+type otlpPBDataPoint struct {
+	TimeUnixNano uint64            `protobuf:"fixed64,3,opt,name=time_unix_nano,proto3"`
+	AsDouble     float64           `protobuf:"fixed64,4,opt,name=as_double,proto3"`
+	Attributes   []*otlpPBKeyValue `protobuf:"bytes,7,rep,name=attributes,proto3"`
+}
+
+func (m *otlpPBDataPoint) Reset()         { *m = otlpPBDataPoint{} }
+func (m *otlpPBDataPoint) String() string { return proto.CompactTextString(m) }
+func (*otlpPBDataPoint) ProtoMessage()    {}
+
+type otlpPBKeyValue struct {
+	Key   string          `protobuf:"bytes,1,opt,name=key,proto3"`
+	Value *otlpPBAnyValue `protobuf:"bytes,2,opt,name=value,proto3"`
+}
+
+func (m *otlpPBKeyValue) Reset()         { *m = otlpPBKeyValue{} }
+func (m *otlpPBKeyValue) String() string { return proto.CompactTextString(m) }
+func (*otlpPBKeyValue) ProtoMessage()    {}
+
+type otlpPBAnyValue struct {
+	StringValue string `protobuf:"bytes,1,opt,name=string_value,proto3"`
+}
+
+func (m *otlpPBAnyValue) Reset()         { *m = otlpPBAnyValue{} }
+func (m *otlpPBAnyValue) String() string { return proto.CompactTextString(m) }
+func (*otlpPBAnyValue) ProtoMessage()    {}
+
+func otlpTestRequest() otlpMetricsRequest {
+	req := generateOTLP([][]prompb.Label{
+		{{Name: "__name__", Value: "up"}, {Name: "instance", Value: "up-0"}},
+		{{Name: "__name__", Value: "up"}, {Name: "instance", Value: "up-1"}},
+		{{Name: "__name__", Value: "heartbeat"}},
+	})
+	req.ResourceMetrics[0].Resource.Attributes = []otlpKeyValue{{Key: "service.name", Value: otlpAnyValue{StringValue: "up"}}}
+
+	return req
+}
+
+func TestOTLPEncodeProtobuf(t *testing.T) {
+	req := otlpTestRequest()
+
+	b, contentType, err := req.encode(writeProtocolOTLPProtobuf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if contentType != "application/x-protobuf" {
+		t.Errorf("expected content type application/x-protobuf, got %s", contentType)
+	}
+
+	var decoded otlpPBRequest
+	if err := proto.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(decoded.ResourceMetrics) != 1 || len(decoded.ResourceMetrics[0].ScopeMetrics) != 1 {
+		t.Fatalf("expected one resource and scope, got %v", decoded.String())
+	}
+
+	rm := decoded.ResourceMetrics[0]
+
+	if attrs := rm.Resource.Attributes; len(attrs) != 1 || attrs[0].Key != "service.name" || attrs[0].Value.StringValue != "up" {
+		t.Errorf("expected resource attribute service.name=up, got %v", attrs)
+	}
+
+	sm := rm.ScopeMetrics[0]
+
+	if sm.Scope.Name != otlpScopeName {
+		t.Errorf("expected scope %q, got %q", otlpScopeName, sm.Scope.Name)
+	}
+
+	expected := req.ResourceMetrics[0].ScopeMetrics[0].Metrics
+
+	if len(sm.Metrics) != len(expected) {
+		t.Fatalf("expected %d metrics, got %d", len(expected), len(sm.Metrics))
+	}
+
+	for i, m := range sm.Metrics {
+		if m.Name != expected[i].Name {
+			t.Errorf("metric %d: expected name %q, got %q", i, expected[i].Name, m.Name)
+		}
+
+		if len(m.Gauge.DataPoints) != len(expected[i].Gauge.DataPoints) {
+			t.Fatalf("metric %q: expected %d data points, got %d", m.Name, len(expected[i].Gauge.DataPoints), len(m.Gauge.DataPoints))
+		}
+
+		for j, dp := range m.Gauge.DataPoints {
+			e := expected[i].Gauge.DataPoints[j]
+
+			if dp.TimeUnixNano != e.TimeUnixNano || dp.AsDouble != e.AsDouble {
+				t.Errorf("metric %q: expected data point %v at %d, got %v at %d", m.Name, e.AsDouble, e.TimeUnixNano, dp.AsDouble, dp.TimeUnixNano)
+			}
+
+			attrs := make([]otlpKeyValue, 0, len(dp.Attributes))
+			for _, a := range dp.Attributes {
+				attrs = append(attrs, otlpKeyValue{Key: a.Key, Value: otlpAnyValue{StringValue: a.Value.StringValue}})
+			}
+
+			if !reflect.DeepEqual(attrs, e.Attributes) {
+				t.Errorf("metric %q: expected attributes %v, got %v", m.Name, e.Attributes, attrs)
+			}
+		}
+	}
+}
+
+func TestOTLPEncodeJSON(t *testing.T) {
+	req := otlpTestRequest()
+
+	b, contentType, err := req.encode(writeProtocolOTLPJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if contentType != "application/json" {
+		t.Errorf("expected content type application/json, got %s", contentType)
+	}
+
+	// The body is decoded generically to check the field names and types of the OTLP/HTTP JSON encoding.
+	var body struct {
+		ResourceMetrics []struct {
+			Resource struct {
+				Attributes []map[string]interface{} `json:"attributes"`
+			} `json:"resource"`
+			ScopeMetrics []struct {
+				Scope   map[string]interface{} `json:"scope"`
+				Metrics []struct {
+					Name  string `json:"name"`
+					Gauge struct {
+						DataPoints []map[string]interface{} `json:"dataPoints"`
+					} `json:"gauge"`
+				} `json:"metrics"`
+			} `json:"scopeMetrics"`
+		} `json:"resourceMetrics"`
+	}
+
+	if err := json.Unmarshal(b, &body); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(body.ResourceMetrics) != 1 || len(body.ResourceMetrics[0].ScopeMetrics) != 1 {
+		t.Fatalf("expected one resource and scope, got %s", b)
+	}
+
+	rm := body.ResourceMetrics[0]
+
+	if attrs := rm.Resource.Attributes; len(attrs) != 1 || attrs[0]["key"] != "service.name" ||
+		!reflect.DeepEqual(attrs[0]["value"], map[string]interface{}{"stringValue": "up"}) {
+		t.Errorf("expected resource attribute service.name=up, got %v", attrs)
+	}
+
+	sm := rm.ScopeMetrics[0]
+
+	if sm.Scope["name"] != otlpScopeName {
+		t.Errorf("expected scope %q, got %v", otlpScopeName, sm.Scope)
+	}
+
+	if len(sm.Metrics) != 2 || sm.Metrics[0].Name != "up" || sm.Metrics[1].Name != "heartbeat" {
+		t.Fatalf("expected metrics up and heartbeat, got %s", b)
+	}
+
+	expected := req.ResourceMetrics[0].ScopeMetrics[0].Metrics[0].Gauge.DataPoints[0]
+	dp := sm.Metrics[0].Gauge.DataPoints[0]
+
+	// 64 bit integers are encoded as strings, as JSON numbers cannot represent them exactly.
+	if dp["timeUnixNano"] != strconv.FormatUint(expected.TimeUnixNano, 10) {
+		t.Errorf("expected timeUnixNano %q, got %#v", strconv.FormatUint(expected.TimeUnixNano, 10), dp["timeUnixNano"])
+	}
+
+	if dp["asDouble"] != expected.AsDouble {
+		t.Errorf("expected asDouble %v, got %#v", expected.AsDouble, dp["asDouble"])
+	}
+
+	attrs := []interface{}{map[string]interface{}{"key": "instance", "value": map[string]interface{}{"stringValue": "up-0"}}}
+	if !reflect.DeepEqual(dp["attributes"], attrs) {
+		t.Errorf("expected attributes %v, got %v", attrs, dp["attributes"])
+	}
+}
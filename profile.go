@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// The types of the phases of a load profile.
+const (
+	// loadPhaseRamp increases or decreases the rate linearly over the phase.
+	loadPhaseRamp = "ramp"
+	// loadPhaseStep increases or decreases the rate in equal steps, each reported on separately.
+	loadPhaseStep = "step"
+	// loadPhaseSpike holds a rate for a short phase, typically well above the rate of its neighbours.
+	loadPhaseSpike = "spike"
+	// loadPhaseSoak holds a rate for a long phase.
+	loadPhaseSoak = "soak"
+)
+
+// loadPhase is a phase of a load profile, with its own rate and duration.
+type loadPhase struct {
+	Name     string        `yaml:"name"`
+	Type     string        `yaml:"type"`
+	Duration time.Duration `yaml:"duration"`
+	// SamplesPerSecond and RequestsPerSecond are the rate at the end of the phase. Only one of them can be set.
+	SamplesPerSecond  float64 `yaml:"samples_per_second"`
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	// From is the rate ramps and steps start from, in the unit of the rate of the phase.
+	// It defaults to the rate the previous phase ended at, or 0 for the first phase.
+	From *float64 `yaml:"from"`
+	// Steps is the number of steps of step phases.
+	Steps int `yaml:"steps"`
+}
+
+// validate checks the phase and names it after its position and type if it has no name.
+func (p *loadPhase) validate(index int) error {
+	if p.Name == "" {
+		p.Name = fmt.Sprintf("%d-%s", index+1, p.Type)
+	}
+
+	switch p.Type {
+	case loadPhaseRamp, loadPhaseSpike, loadPhaseSoak:
+		if p.Steps != 0 {
+			return fmt.Errorf("steps are only supported by %s phases", loadPhaseStep)
+		}
+	case loadPhaseStep:
+		if p.Steps < 1 {
+			return fmt.Errorf("steps must be at least 1")
+		}
+	default:
+		return fmt.Errorf("unknown type %q", p.Type)
+	}
+
+	switch {
+	case p.Duration <= 0:
+		return fmt.Errorf("duration must be positive")
+	case (p.SamplesPerSecond > 0) == (p.RequestsPerSecond > 0):
+		return fmt.Errorf("exactly one of samples_per_second and requests_per_second must be positive")
+	case p.From != nil && (p.Type == loadPhaseSpike || p.Type == loadPhaseSoak):
+		return fmt.Errorf("from is only supported by %s and %s phases", loadPhaseRamp, loadPhaseStep)
+	case p.From != nil && *p.From < 0:
+		return fmt.Errorf("from must not be negative")
+	}
+
+	return nil
+}
+
+// requestRates returns the request rates the phase starts from and ends at, given the rate the previous phase ended at.
+func (p loadPhase) requestRates(samplesPerRequest int, previous float64) (from, to float64) {
+	scale := 1.0
+	to = p.RequestsPerSecond
+
+	if p.SamplesPerSecond > 0 {
+		scale = 1 / float64(samplesPerRequest)
+		to = p.SamplesPerSecond * scale
+	}
+
+	from = previous
+	if p.From != nil {
+		from = *p.From * scale
+	}
+
+	return from, to
+}
+
+// rateAt returns the step and the request rate at the given time since the start of the phase.
+func (p loadPhase) rateAt(from, to float64, elapsed time.Duration) (step int, rate float64) {
+	progress := elapsed.Seconds() / p.Duration.Seconds()
+
+	switch p.Type {
+	case loadPhaseRamp:
+		return 0, from + (to-from)*progress
+	case loadPhaseStep:
+		step = int(math.Min(math.Floor(progress*float64(p.Steps)), float64(p.Steps-1)))
+		return step, from + (to-from)*float64(step+1)/float64(p.Steps)
+	default:
+		return 0, to
+	}
+}
+
+// loadPosition identifies a phase of the load profile and a step within it, whose results are reported separately.
+type loadPosition struct {
+	phase int
+	step  int
+}
+
+// at returns the position in the load profile and the target request rate at the given time since the start of the run.
+// Without phases, the rate of the load options applies for the whole run.
+// done reports whether the run is past the last phase.
+func (c loadConfig) at(w *workload, elapsed time.Duration) (pos loadPosition, rate float64, done bool) {
+	if len(c.Phases) == 0 {
+		return loadPosition{}, c.requestRate(w), false
+	}
+
+	var (
+		start    time.Duration
+		previous float64
+	)
+
+	for i, p := range c.Phases {
+		from, to := p.requestRates(c.samplesPerRequest(w), previous)
+
+		if elapsed < start+p.Duration {
+			step, rate := p.rateAt(from, to, elapsed-start)
+			return loadPosition{phase: i, step: step}, rate, false
+		}
+
+		start += p.Duration
+		previous = to
+	}
+
+	return loadPosition{phase: len(c.Phases)}, 0, true
+}
+
+// duration returns the total duration of the phases of the load profile, or 0 without phases.
+func (c loadConfig) duration() time.Duration {
+	var d time.Duration
+	for _, p := range c.Phases {
+		d += p.Duration
+	}
+
+	return d
+}
+
+// keyvals returns the log fields identifying the position in the load profile, if there are phases.
+func (c loadConfig) keyvals(pos loadPosition) []interface{} {
+	if pos.phase >= len(c.Phases) {
+		return nil
+	}
+
+	p := c.Phases[pos.phase]
+	kvs := []interface{}{"phase", p.Name, "type", p.Type}
+
+	if p.Type == loadPhaseStep {
+		kvs = append(kvs, "step", fmt.Sprintf("%d/%d", pos.step+1, p.Steps))
+	}
+
+	return kvs
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"math"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/pkg/errors"
+)
+
+// protoField encodes a single field of a protobuf message.
+// It is used to encode messages whose definitions are not available as a dependency.
+type protoField func(b *proto.Buffer) error
+
+func varintField(num int, v uint64) protoField {
+	return func(b *proto.Buffer) error {
+		if v == 0 {
+			// Fields with default values are omitted.
+			return nil
+		}
+
+		if err := b.EncodeVarint(uint64(num)<<3 | proto.WireVarint); err != nil {
+			return err
+		}
+
+		return b.EncodeVarint(v)
+	}
+}
+
+// sint32Field encodes a zigzag encoded signed integer.
+func sint32Field(num int, v int32) protoField {
+	return varintField(num, uint64((uint32(v)<<1)^uint32(v>>31)))
+}
+
+// packedSint64Field encodes repeated zigzag encoded signed integers in packed form.
+func packedSint64Field(num int, vs []int64) protoField {
+	return func(b *proto.Buffer) error {
+		if len(vs) == 0 {
+			return nil
+		}
+
+		p := proto.NewBuffer(nil)
+		for _, v := range vs {
+			if err := p.EncodeZigzag64(uint64(v)); err != nil {
+				return err
+			}
+		}
+
+		return bytesField(num, p.Bytes())(b)
+	}
+}
+
+// packedUint32Field encodes repeated unsigned integers in packed form.
+func packedUint32Field(num int, vs []uint32) protoField {
+	return func(b *proto.Buffer) error {
+		if len(vs) == 0 {
+			return nil
+		}
+
+		p := proto.NewBuffer(nil)
+		for _, v := range vs {
+			if err := p.EncodeVarint(uint64(v)); err != nil {
+				return err
+			}
+		}
+
+		return bytesField(num, p.Bytes())(b)
+	}
+}
+
+func fixed64Field(num int, v uint64) protoField {
+	return func(b *proto.Buffer) error {
+		if v == 0 {
+			return nil
+		}
+
+		if err := b.EncodeVarint(uint64(num)<<3 | proto.WireFixed64); err != nil {
+			return err
+		}
+
+		return b.EncodeFixed64(v)
+	}
+}
+
+func doubleField(num int, v float64) protoField {
+	return fixed64Field(num, math.Float64bits(v))
+}
+
+func bytesField(num int, v []byte) protoField {
+	return func(b *proto.Buffer) error {
+		if err := b.EncodeVarint(uint64(num)<<3 | proto.WireBytes); err != nil {
+			return err
+		}
+
+		return b.EncodeRawBytes(v)
+	}
+}
+
+func stringField(num int, v string) protoField {
+	return func(b *proto.Buffer) error {
+		if v == "" {
+			return nil
+		}
+
+		return bytesField(num, []byte(v))(b)
+	}
+}
+
+// messageField encodes an embedded message, whose fields are encoded by the given function.
+func messageField(num int, fields ...protoField) protoField {
+	return func(b *proto.Buffer) error {
+		m := proto.NewBuffer(nil)
+		if err := encodeFields(m, fields...); err != nil {
+			return err
+		}
+
+		return bytesField(num, m.Bytes())(b)
+	}
+}
+
+func encodeFields(b *proto.Buffer, fields ...protoField) error {
+	for _, f := range fields {
+		if err := f(b); err != nil {
+			return errors.Wrap(err, "encoding proto")
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	promapiv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+)
+
+// rangeSpec specifies the time range and resolution of a range query.
+type rangeSpec struct {
+	// Lookback is the length of the range, which ends at the time the query is run.
+	Lookback time.Duration `yaml:"lookback"`
+	// Step is the resolution of the result. Defaults to a 250th of the lookback.
+	Step time.Duration `yaml:"step"`
+	// Align aligns the range to multiples of the step, which allows responses to be cached.
+	Align bool `yaml:"align"`
+}
+
+func (r rangeSpec) validate() error {
+	if r.Lookback <= 0 {
+		return errors.New("lookback must be positive")
+	}
+
+	if r.Step < 0 || r.Step > r.Lookback {
+		return errors.New("step must be positive and not greater than lookback")
+	}
+
+	return nil
+}
+
+func (r rangeSpec) step() time.Duration {
+	if r.Step > 0 {
+		return r.Step
+	}
+
+	if s := r.Lookback / 250; s > time.Millisecond {
+		return s.Truncate(time.Millisecond)
+	}
+
+	return time.Millisecond
+}
+
+// window returns the range to query, ending at the given time.
+func (r rangeSpec) window(end time.Time) promapiv1.Range {
+	step := r.step()
+	start := end.Add(-r.Lookback)
+
+	if r.Align {
+		start = alignTime(start, step)
+		end = alignTime(end, step)
+	}
+
+	return promapiv1.Range{Start: start, End: end, Step: step}
+}
+
+// alignTime rounds the time down to a multiple of the step since the Unix epoch.
+func alignTime(t time.Time, step time.Duration) time.Time {
+	ns := t.UnixNano()
+	return time.Unix(0, ns-ns%int64(step))
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRangeSpecWindow(t *testing.T) {
+	var (
+		aligned   = time.Date(2021, 3, 4, 5, 6, 0, 0, time.UTC)
+		unaligned = aligned.Add(42*time.Second + 7*time.Millisecond)
+	)
+
+	for _, tc := range []struct {
+		name string
+		spec rangeSpec
+		// at is the time the query is run at.
+		at    time.Time
+		start time.Time
+		end   time.Time
+		step  time.Duration
+	}{
+		{
+			name:  "explicit step",
+			spec:  rangeSpec{Lookback: time.Hour, Step: time.Minute},
+			at:    unaligned,
+			start: unaligned.Add(-time.Hour),
+			end:   unaligned,
+			step:  time.Minute,
+		},
+		{
+			name:  "default step",
+			spec:  rangeSpec{Lookback: time.Hour},
+			at:    unaligned,
+			start: unaligned.Add(-time.Hour),
+			end:   unaligned,
+			step:  time.Hour / 250,
+		},
+		{
+			name:  "default step truncated to milliseconds",
+			spec:  rangeSpec{Lookback: time.Second + time.Millisecond},
+			at:    unaligned,
+			start: unaligned.Add(-time.Second - time.Millisecond),
+			end:   unaligned,
+			step:  4 * time.Millisecond,
+		},
+		{
+			name:  "default step of at least a millisecond",
+			spec:  rangeSpec{Lookback: 100 * time.Millisecond},
+			at:    unaligned,
+			start: unaligned.Add(-100 * time.Millisecond),
+			end:   unaligned,
+			step:  time.Millisecond,
+		},
+		{
+			name:  "aligned to step",
+			spec:  rangeSpec{Lookback: time.Hour, Step: time.Minute, Align: true},
+			at:    unaligned,
+			start: aligned.Add(-time.Hour),
+			end:   aligned,
+			step:  time.Minute,
+		},
+		{
+			name:  "already aligned",
+			spec:  rangeSpec{Lookback: time.Hour, Step: time.Minute, Align: true},
+			at:    aligned,
+			start: aligned.Add(-time.Hour),
+			end:   aligned,
+			step:  time.Minute,
+		},
+		{
+			// With a lookback that is not a multiple of the step, the start is aligned on its own.
+			name:  "lookback not a multiple of the step",
+			spec:  rangeSpec{Lookback: 90 * time.Second, Step: time.Minute, Align: true},
+			at:    aligned,
+			start: aligned.Add(-2 * time.Minute),
+			end:   aligned,
+			step:  time.Minute,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := tc.spec.window(tc.at)
+
+			if !w.Start.Equal(tc.start) {
+				t.Errorf("expected start %s, got %s", tc.start, w.Start.UTC())
+			}
+
+			if !w.End.Equal(tc.end) {
+				t.Errorf("expected end %s, got %s", tc.end, w.End.UTC())
+			}
+
+			if w.Step != tc.step {
+				t.Errorf("expected step %s, got %s", tc.step, w.Step)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// liveOptions holds the options in effect, which are replaced whenever the configuration is reloaded.
+type liveOptions struct {
+	mtx  sync.RWMutex
+	opts options
+}
+
+func newLiveOptions(opts options) *liveOptions {
+	return &liveOptions{opts: opts}
+}
+
+func (o *liveOptions) get() options {
+	o.mtx.RLock()
+	defer o.mtx.RUnlock()
+
+	return o.opts
+}
+
+func (o *liveOptions) set(opts options) {
+	o.mtx.Lock()
+	defer o.mtx.Unlock()
+
+	o.opts = opts
+}
+
+// tenant returns the tenant with the given index as currently configured.
+func (o *liveOptions) tenant(i int) tenant {
+	return o.get().Tenants[i]
+}
+
+// reloader reloads the configuration and replaces the live options with the new ones if they are valid.
+// If they are not, the previous options keep being used.
+type reloader struct {
+	l    log.Logger
+	reg  *prometheus.Registry
+	m    metrics
+	live *liveOptions
+	load func(l log.Logger) (options, error)
+
+	mtx    sync.Mutex
+	states map[string]fileState
+}
+
+func newReloader(
+	l log.Logger,
+	reg *prometheus.Registry,
+	m metrics,
+	live *liveOptions,
+	load func(l log.Logger) (options, error),
+) *reloader {
+	r := &reloader{l: l, reg: reg, m: m, live: live, load: load}
+	r.states = r.stat(live.get().Files)
+
+	m.configLastReloadSuccessful.Set(1)
+
+	return r
+}
+
+func (r *reloader) reload() error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	prev := r.live.get()
+	// Failed reloads are not retried until the files change again.
+	r.states = r.stat(prev.Files)
+
+	opts, err := r.load(r.l)
+	if err == nil {
+		err = checkReload(prev, opts)
+	}
+
+	if err != nil {
+		r.m.configReloads.WithLabelValues("error").Inc()
+		r.m.configLastReloadSuccessful.Set(0)
+		level.Error(r.l).Log("msg", "failed to reload configuration, keep using previous one", "err", err)
+
+		return err
+	}
+
+	if opts.Listen != prev.Listen || opts.Period != prev.Period || opts.Duration != prev.Duration ||
+		opts.QueryConcurrency != prev.QueryConcurrency || opts.InitialQueryDelay != prev.InitialQueryDelay ||
+		opts.ReloadInterval != prev.ReloadInterval {
+		level.Warn(r.l).Log("msg", "changes to the listen address, period, duration, query concurrency, "+
+			"initial query delay and reload interval only take effect after a restart")
+	}
+
+	for _, t := range prev.Tenants {
+		for _, c := range t.collectors() {
+			r.reg.Unregister(c)
+		}
+	}
+
+	for _, t := range opts.Tenants {
+		registerCollectors(r.reg, t.collectors()...)
+	}
+
+	r.live.set(opts)
+	r.states = r.stat(opts.Files)
+
+	closeIdleConnections(prev.Transports)
+
+	r.m.configReloads.WithLabelValues("success").Inc()
+	r.m.configLastReloadSuccessful.Set(1)
+	level.Info(r.l).Log("msg", "reloaded configuration")
+
+	return nil
+}
+
+// closeIdleConnections closes the idle connections of the transports of replaced options, which are not used for new requests.
+// The default transport is shared by all options and keeps its connections.
+func closeIdleConnections(transports []http.RoundTripper) {
+	for _, rt := range transports {
+		if c, ok := rt.(interface{ CloseIdleConnections() }); ok && rt != http.DefaultTransport {
+			c.CloseIdleConnections()
+		}
+	}
+}
+
+// checkReload checks that the reloaded options can replace the previous ones without a restart.
+// The tenants and endpoints that are probed, the endpoint type and the load mode cannot be changed,
+// as they determine the components that are run.
+func checkReload(prev, opts options) error {
+	if opts.EndpointType != prev.EndpointType {
+		return errors.New("changing the endpoint type requires a restart")
+	}
+
+	if opts.Load.Enabled != prev.Load.Enabled {
+		return errors.New("enabling or disabling the load mode requires a restart")
+	}
+
+	// The series are distributed between the load writers when they start.
+	if opts.Load.Enabled && (opts.Load.Writers != prev.Load.Writers || opts.Series != prev.Series) {
+		return errors.New("changing the number of load writers or series in load mode requires a restart")
+	}
+
+	if len(opts.Tenants) != len(prev.Tenants) {
+		return errors.New("adding or removing tenants requires a restart")
+	}
+
+	for i, t := range opts.Tenants {
+		p := prev.Tenants[i]
+
+		if t.Name != p.Name {
+			return errors.New("adding, removing or renaming tenants requires a restart")
+		}
+
+		if (t.WriteEndpoint == nil) != (p.WriteEndpoint == nil) || (t.ReadEndpoint == nil) != (p.ReadEndpoint == nil) {
+			return errors.New("adding or removing endpoints requires a restart")
+		}
+	}
+
+	if (opts.Queries == nil) != (prev.Queries == nil) {
+		return errors.New("adding or removing all queries requires a restart")
+	}
+
+	return nil
+}
+
+// changed checks whether any of the files the options were read from changed since the last reload.
+func (r *reloader) changed() bool {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	states := r.stat(r.live.get().Files)
+	for f, s := range states {
+		if p, ok := r.states[f]; !ok || p != s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (r *reloader) stat(files []string) map[string]fileState {
+	states := make(map[string]fileState, len(files))
+
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			continue
+		}
+
+		states[f] = fileState{modTime: fi.ModTime(), size: fi.Size()}
+	}
+
+	return states
+}
+
+// run reloads the configuration on SIGHUP and, if an interval is given, whenever the files it was read from change.
+func (r *reloader) run(ctx context.Context, hup <-chan os.Signal, interval time.Duration) {
+	var tick <-chan time.Time
+
+	if interval > 0 {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+
+		tick = t.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			level.Info(r.l).Log("msg", "caught SIGHUP, reloading configuration")
+			_ = r.reload()
+		case <-tick:
+			if r.changed() {
+				level.Info(r.l).Log("msg", "configuration files changed, reloading configuration")
+				_ = r.reload()
+			}
+		}
+	}
+}
+
+// ServeHTTP reloads the configuration on POST and PUT requests.
+func (r *reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost && req.Method != http.MethodPut {
+		http.Error(w, "only POST and PUT requests are allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := r.reload(); err != nil {
+		http.Error(w, "failed to reload configuration: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// idleTransport counts how often its idle connections are closed.
+type idleTransport struct {
+	http.RoundTripper
+	closed int
+}
+
+func (t *idleTransport) CloseIdleConnections() {
+	t.closed++
+}
+
+func TestReloadClosesIdleConnections(t *testing.T) {
+	var (
+		prev = &idleTransport{}
+		next = &idleTransport{}
+		live = newLiveOptions(options{Transports: []http.RoundTripper{prev, http.DefaultTransport}})
+		load = func(log.Logger) (options, error) {
+			return options{Transports: []http.RoundTripper{next, http.DefaultTransport}}, nil
+		}
+	)
+
+	r := newReloader(log.NewNopLogger(), prometheus.NewRegistry(), registerMetrics(prometheus.NewRegistry()), live, load)
+
+	if err := r.reload(); err != nil {
+		t.Fatal(err)
+	}
+
+	if prev.closed != 1 {
+		t.Errorf("expected the idle connections of the replaced transport to be closed once, got %d", prev.closed)
+	}
+
+	if next.closed != 0 {
+		t.Errorf("expected the idle connections of the new transport to be kept, got %d closes", next.closed)
+	}
+}
@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+)
+
+const (
+	// readProtocolQuery reads the written series back through the Prometheus HTTP query API.
+	readProtocolQuery = "query"
+	// readProtocolRemoteRead reads the written series back through the Prometheus remote-read protocol.
+	readProtocolRemoteRead = "remote-read"
+
+	// remoteReadLookback is how far back in time samples are requested, mirroring the PromQL lookback delta.
+	remoteReadLookback = 5 * time.Minute
+	// remoteReadFrameLimit is the maximum size of a single frame of a streamed response.
+	remoteReadFrameLimit = 5e+7
+)
+
+var castagnoliTable = crc32.MakeTable(crc32.Castagnoli)
+
+// readFunc reads the series of the workload back and verifies their values.
+type readFunc func(
+	ctx context.Context,
+	rt http.RoundTripper,
+	endpoint *url.URL,
+	w *workload,
+	ago, latency time.Duration,
+	o prometheus.Observer,
+) error
+
+func reader(endpointType, protocol string) readFunc {
+	switch {
+	case endpointType == endpointTypeLogs:
+		return readLogs
+	case protocol == readProtocolRemoteRead:
+		return remoteRead
+	default:
+		return read
+	}
+}
+
+func remoteRead(
+	ctx context.Context,
+	rt http.RoundTripper,
+	endpoint *url.URL,
+	w *workload,
+	ago, latency time.Duration,
+	o prometheus.Observer,
+) error {
+	sets, err := w.labelSets()
+	if err != nil {
+		return errors.Wrap(err, "generate series")
+	}
+
+	ts := time.Now().Add(ago)
+	end := ts.UnixNano() / int64(time.Millisecond)
+	start := ts.Add(-remoteReadLookback).UnixNano() / int64(time.Millisecond)
+
+	ms := matchers(sets)
+	pms := make([]*prompb.LabelMatcher, len(ms))
+
+	for i := range ms {
+		pms[i] = &ms[i]
+	}
+
+	rreq := &prompb.ReadRequest{
+		Queries: []*prompb.Query{{
+			StartTimestampMs: start,
+			EndTimestampMs:   end,
+			Matchers:         pms,
+			Hints:            &prompb.ReadHints{StartMs: start, EndMs: end},
+		}},
+		AcceptedResponseTypes: []prompb.ReadRequest_ResponseType{
+			prompb.ReadRequest_STREAMED_XOR_CHUNKS,
+			prompb.ReadRequest_SAMPLES,
+		},
+	}
+
+	buf, err := proto.Marshal(rreq)
+	if err != nil {
+		return errors.Wrap(err, "marshalling proto")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, endpoint.String(), bytes.NewReader(snappy.Encode(nil, buf)))
+	if err != nil {
+		return errors.Wrap(err, "creating request")
+	}
+
+	req.Header.Add("Content-Encoding", "snappy")
+	req.Header.Add("Accept-Encoding", "snappy")
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("X-Prometheus-Remote-Read-Version", "0.1.0")
+
+	res, err := (&http.Client{Transport: rt}).Do(req.WithContext(ctx))
+	if err != nil {
+		return errors.Wrap(err, "remote read request failed")
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(res.Body)
+		return errors.Errorf("remote read request failed with status %s: %s", res.Status, strings.TrimSpace(string(body)))
+	}
+
+	var series []*prompb.TimeSeries
+	if strings.HasPrefix(res.Header.Get("Content-Type"), "application/x-streamed-protobuf") {
+		series, err = readStreamed(res.Body, end)
+	} else {
+		series, err = readSamples(res.Body)
+	}
+
+	if err != nil {
+		return errors.Wrap(err, "remote read response parse failed")
+	}
+
+	return verify(sets, latestSamples(series), latency, o)
+}
+
+// readSamples decodes a snappy compressed, sample based remote-read response.
+func readSamples(r io.Reader) ([]*prompb.TimeSeries, error) {
+	compressed, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, errors.Wrap(err, "reading response")
+	}
+
+	b, err := snappy.Decode(nil, compressed)
+	if err != nil {
+		return nil, errors.Wrap(err, "decompressing response")
+	}
+
+	var rres prompb.ReadResponse
+	if err := proto.Unmarshal(b, &rres); err != nil {
+		return nil, errors.Wrap(err, "unmarshalling response")
+	}
+
+	if len(rres.Results) != 1 {
+		return nil, errors.Errorf("expected one query result, got %d", len(rres.Results))
+	}
+
+	return rres.Results[0].Timeseries, nil
+}
+
+// readStreamed decodes a streamed remote-read response of XOR chunks.
+// Samples after maxt are dropped, as chunks can span beyond the requested time range.
+// Chunks of the same series split across frames are merged into one series.
+func readStreamed(r io.Reader, maxt int64) ([]*prompb.TimeSeries, error) {
+	var (
+		fr     = newFrameReader(r, remoteReadFrameLimit)
+		byKey  = map[string]*prompb.TimeSeries{}
+		series []*prompb.TimeSeries
+	)
+
+	for {
+		var cres prompb.ChunkedReadResponse
+
+		b, err := fr.next()
+		if err == io.EOF {
+			return series, nil
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if err := proto.Unmarshal(b, &cres); err != nil {
+			return nil, errors.Wrap(err, "unmarshalling frame")
+		}
+
+		for _, cs := range cres.ChunkedSeries {
+			key := labelsKey(cs.Labels)
+
+			ts, ok := byKey[key]
+			if !ok {
+				ts = &prompb.TimeSeries{Labels: cs.Labels}
+				byKey[key] = ts
+				series = append(series, ts)
+			}
+
+			for _, c := range cs.Chunks {
+				if c.Type != prompb.Chunk_XOR {
+					return nil, errors.Errorf("unsupported chunk encoding %s", c.Type)
+				}
+
+				chk, err := chunkenc.FromData(chunkenc.EncXOR, c.Data)
+				if err != nil {
+					return nil, errors.Wrap(err, "decoding chunk")
+				}
+
+				it := chk.Iterator(nil)
+				for it.Next() {
+					t, v := it.At()
+					if t > maxt {
+						break
+					}
+
+					ts.Samples = append(ts.Samples, prompb.Sample{Timestamp: t, Value: v})
+				}
+
+				if err := it.Err(); err != nil {
+					return nil, errors.Wrap(err, "iterating chunk")
+				}
+			}
+		}
+	}
+}
+
+func labelsKey(ls []prompb.Label) string {
+	var b strings.Builder
+
+	for _, l := range ls {
+		b.WriteString(l.Name)
+		b.WriteByte('\xff')
+		b.WriteString(l.Value)
+		b.WriteByte('\xff')
+	}
+
+	return b.String()
+}
+
+// latestSamples converts the series to a vector holding the most recent sample of every series.
+func latestSamples(series []*prompb.TimeSeries) model.Vector {
+	vec := make(model.Vector, 0, len(series))
+
+	for _, ts := range series {
+		if len(ts.Samples) == 0 {
+			continue
+		}
+
+		latest := ts.Samples[0]
+		for _, s := range ts.Samples[1:] {
+			if s.Timestamp > latest.Timestamp {
+				latest = s
+			}
+		}
+
+		metric := make(model.Metric, len(ts.Labels))
+		for _, l := range ts.Labels {
+			metric[model.LabelName(l.Name)] = model.LabelValue(l.Value)
+		}
+
+		vec = append(vec, &model.Sample{
+			Metric:    metric,
+			Value:     model.SampleValue(latest.Value),
+			Timestamp: model.Time(latest.Timestamp),
+		})
+	}
+
+	return vec
+}
+
+// frameReader reads the length-delimited, checksummed frames of a streamed remote-read response.
+type frameReader struct {
+	b     *bufio.Reader
+	limit uint64
+	data  []byte
+}
+
+func newFrameReader(r io.Reader, limit uint64) *frameReader {
+	return &frameReader{b: bufio.NewReader(r), limit: limit}
+}
+
+// next returns the next frame or io.EOF if there are no more frames.
+// The returned slice is only valid until the next call.
+func (r *frameReader) next() ([]byte, error) {
+	size, err := binary.ReadUvarint(r.b)
+	if err != nil {
+		return nil, err
+	}
+
+	if size > r.limit {
+		return nil, errors.Errorf("frame size %d exceeds the limit of %d bytes", size, r.limit)
+	}
+
+	if uint64(cap(r.data)) < size {
+		r.data = make([]byte, size)
+	}
+
+	r.data = r.data[:size]
+
+	var sum uint32
+	if err := binary.Read(r.b, binary.BigEndian, &sum); err != nil {
+		return nil, errors.Wrap(err, "reading frame checksum")
+	}
+
+	if _, err := io.ReadFull(r.b, r.data); err != nil {
+		return nil, errors.Wrap(err, "reading frame")
+	}
+
+	if crc32.Checksum(r.data, castagnoliTable) != sum {
+		return nil, errors.New("corrupted frame, checksum mismatch")
+	}
+
+	return r.data, nil
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/gogo/protobuf/proto"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+const (
+	// remoteWriteVersionHeader is the header announcing the version of the remote-write protocol of a request.
+	remoteWriteVersionHeader = "X-Prometheus-Remote-Write-Version"
+	remoteWriteVersion1      = "0.1.0"
+	remoteWriteVersion2      = "2.0.0"
+	// remoteWriteContentTypeV1 and remoteWriteContentTypeV2 tell receivers which protobuf message the body holds.
+	remoteWriteContentTypeV1 = "application/x-protobuf"
+	remoteWriteContentTypeV2 = "application/x-protobuf;proto=io.prometheus.write.v2.Request"
+
+	// The headers of remote-write 2.0 responses reporting what the receiver wrote.
+	samplesWrittenHeader    = "X-Prometheus-Remote-Write-Samples-Written"
+	histogramsWrittenHeader = "X-Prometheus-Remote-Write-Histograms-Written"
+	exemplarsWrittenHeader  = "X-Prometheus-Remote-Write-Exemplars-Written"
+)
+
+// isRemoteWrite reports whether the write protocol is a version of the Prometheus remote-write protocol.
+func isRemoteWrite(protocol string) bool {
+	return protocol == writeProtocolRemoteWrite || protocol == writeProtocolRemoteWriteV2
+}
+
+// remoteWriteHeader returns the headers of a remote-write request of the given content type, version and content encoding.
+func remoteWriteHeader(contentType, version, encoding string) http.Header {
+	h := http.Header{
+		"Content-Type":           []string{contentType},
+		remoteWriteVersionHeader: []string{version},
+	}
+
+	if encoding != "" {
+		h.Set("Content-Encoding", encoding)
+	}
+
+	return h
+}
+
+// symbolTable interns the strings of a remote-write 2.0 request, which refers to them by their index.
+type symbolTable struct {
+	symbols []string
+	refs    map[string]uint32
+}
+
+func newSymbolTable() *symbolTable {
+	// The first symbol is always the empty string.
+	return &symbolTable{symbols: []string{""}, refs: map[string]uint32{"": 0}}
+}
+
+func (t *symbolTable) ref(s string) uint32 {
+	if r, ok := t.refs[s]; ok {
+		return r
+	}
+
+	r := uint32(len(t.symbols))
+	t.symbols = append(t.symbols, s)
+	t.refs[s] = r
+
+	return r
+}
+
+// labelRefs returns the references to the names and values of the labels, alternating.
+func (t *symbolTable) labelRefs(lset []prompb.Label) []uint32 {
+	refs := make([]uint32, 0, 2*len(lset))
+	for _, l := range lset {
+		refs = append(refs, t.ref(l.Name), t.ref(l.Value))
+	}
+
+	return refs
+}
+
+// metadataOf returns the metadata of the family the series of the given metric name belongs to,
+// including the _bucket, _sum and _count series of histograms and summaries.
+func (r *writeRequest) metadataOf(name string) (metricMetadata, bool) {
+	for _, m := range r.Metadata {
+		for _, suffix := range []string{"", "_bucket", "_sum", "_count"} {
+			if name == m.MetricFamilyName+suffix {
+				return m, true
+			}
+		}
+	}
+
+	return metricMetadata{}, false
+}
+
+// MarshalV2 encodes the request as an io.prometheus.write.v2.Request:
+//
+//	message Request { repeated string symbols = 4; repeated TimeSeries timeseries = 5; }
+//	message TimeSeries {
+//	  repeated uint32 labels_refs = 1; repeated Sample samples = 2; repeated Histogram histograms = 3;
+//	  repeated Exemplar exemplars = 4; Metadata metadata = 5;
+//	}
+//	message Sample { double value = 1; int64 timestamp = 2; }
+//	message Exemplar { repeated uint32 labels_refs = 1; double value = 2; int64 timestamp = 3; }
+//	message Metadata { MetricType type = 1; uint32 help_ref = 3; uint32 unit_ref = 4; }
+//
+// Histograms are encoded the same way as in remote-write 1.0.
+func (r *writeRequest) MarshalV2() ([]byte, error) {
+	var (
+		symbols = newSymbolTable()
+		series  = make([]protoField, 0, len(r.Timeseries))
+	)
+
+	for i, ts := range r.Timeseries {
+		fs := []protoField{packedUint32Field(1, symbols.labelRefs(ts.Labels))}
+
+		for _, s := range ts.Samples {
+			fs = append(fs, messageField(2, doubleField(1, s.Value), varintField(2, uint64(s.Timestamp))))
+		}
+
+		for _, h := range r.Histograms[i] {
+			fs = append(fs, messageField(3, h.fields()...))
+		}
+
+		for _, e := range r.Exemplars[i] {
+			fs = append(fs, messageField(4,
+				packedUint32Field(1, symbols.labelRefs(e.Labels)), doubleField(2, e.Value), varintField(3, uint64(e.Timestamp))))
+		}
+
+		if m, ok := r.metadataOf(metricName(ts.Labels)); ok {
+			fs = append(fs, messageField(5,
+				varintField(1, uint64(m.Type)), varintField(3, uint64(symbols.ref(m.Help))), varintField(4, uint64(symbols.ref(m.Unit)))))
+		}
+
+		series = append(series, messageField(5, fs...))
+	}
+
+	b := proto.NewBuffer(nil)
+
+	// The empty first symbol is written as well, so the symbols are not encoded with stringField.
+	for _, s := range symbols.symbols {
+		if err := encodeFields(b, bytesField(4, []byte(s))); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := encodeFields(b, series...); err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+// counts returns the number of samples, native histograms and exemplars of the request.
+func (r *writeRequest) counts() (samples, histograms, exemplars int) {
+	for i, ts := range r.Timeseries {
+		samples += len(ts.Samples)
+		histograms += len(r.Histograms[i])
+		exemplars += len(r.Exemplars[i])
+	}
+
+	return samples, histograms, exemplars
+}
+
+// endpointSet is a set of endpoints safe for concurrent use.
+type endpointSet struct {
+	mtx       sync.Mutex
+	endpoints map[string]struct{}
+}
+
+func newEndpointSet() *endpointSet {
+	return &endpointSet{endpoints: map[string]struct{}{}}
+}
+
+func (s *endpointSet) add(endpoint fmt.Stringer) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.endpoints[endpoint.String()] = struct{}{}
+}
+
+func (s *endpointSet) has(endpoint fmt.Stringer) bool {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	_, ok := s.endpoints[endpoint.String()]
+
+	return ok
+}
+
+// remoteWriteV1Endpoints are the endpoints that rejected remote-write 2.0 requests.
+// They outlive reloads, as the receiver behind an endpoint does not change with the configuration.
+var remoteWriteV1Endpoints = newEndpointSet()
+
+// writeV2 sends the request as a remote-write 2.0 request and checks that the receiver reports having written all of it.
+// Receivers that do not support remote-write 2.0 reject the content type with a 415 status,
+// in which case the request is sent again as a remote-write 1.0 request and counted as a fallback,
+// as its written counts cannot be checked.
+// The endpoint is then added to v1, so that later requests are sent as remote-write 1.0 right away.
+func writeV2(
+	ctx context.Context,
+	rt http.RoundTripper,
+	endpoint fmt.Stringer,
+	wreq *writeRequest,
+	c compressor,
+	v1 *endpointSet,
+	fallbacks prometheus.Counter,
+	l log.Logger,
+) error {
+	if v1.has(endpoint) {
+		return write(ctx, rt, endpoint, wreq, c, l)
+	}
+
+	buf, err := wreq.MarshalV2()
+	if err != nil {
+		return errors.Wrap(err, "marshalling proto")
+	}
+
+	body, encoding, err := c.compress(buf)
+	if err != nil {
+		return errors.Wrap(err, "compressing request")
+	}
+
+	header, err := post(ctx, rt, endpoint, body, remoteWriteHeader(remoteWriteContentTypeV2, remoteWriteVersion2, encoding), l)
+
+	var serr statusError
+	if errors.As(err, &serr) && serr.code == http.StatusUnsupportedMediaType {
+		level.Warn(l).Log("msg", "receiver does not support remote-write 2.0, falling back to remote-write 1.0", "err", err)
+		fallbacks.Inc()
+		v1.add(endpoint)
+
+		return write(ctx, rt, endpoint, wreq, c, l)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return verifyWritten(header, wreq)
+}
+
+// verifyWritten checks that the counts reported by the headers of a remote-write 2.0 response match the request.
+// A missing header is only accepted if nothing of its kind was sent.
+func verifyWritten(header http.Header, wreq *writeRequest) error {
+	samples, histograms, exemplars := wreq.counts()
+
+	var failed []string
+
+	for _, c := range []struct {
+		header string
+		sent   int
+	}{
+		{samplesWrittenHeader, samples},
+		{histogramsWrittenHeader, histograms},
+		{exemplarsWrittenHeader, exemplars},
+	} {
+		v := header.Get(c.header)
+		if v == "" {
+			if c.sent > 0 {
+				failed = append(failed, fmt.Sprintf("%s header missing, the receiver may not support remote-write 2.0", c.header))
+			}
+
+			continue
+		}
+
+		written, err := strconv.Atoi(v)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s header is invalid: %v", c.header, err))
+			continue
+		}
+
+		if written != c.sent {
+			failed = append(failed, fmt.Sprintf("%s is %d, sent %d", c.header, written, c.sent))
+		}
+	}
+
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+
+	return nil
+}
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gogo/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// The messages of io.prometheus.write.v2.Request used to decode the output of MarshalV2.
+// Native histograms are left out, as they are encoded the same way as in remote-write 1.0.
+type v2Request struct {
+	Symbols    []string        `protobuf:"bytes,4,rep,name=symbols,proto3"`
+	Timeseries []*v2TimeSeries `protobuf:"bytes,5,rep,name=timeseries,proto3"`
+}
+
+func (m *v2Request) Reset()         { *m = v2Request{} }
+func (m *v2Request) String() string { return proto.CompactTextString(m) }
+func (*v2Request) ProtoMessage()    {}
+
+type v2TimeSeries struct {
+	LabelsRefs []uint32      `protobuf:"varint,1,rep,packed,name=labels_refs,proto3"`
+	Samples    []*v2Sample   `protobuf:"bytes,2,rep,name=samples,proto3"`
+	Exemplars  []*v2Exemplar `protobuf:"bytes,4,rep,name=exemplars,proto3"`
+	Metadata   *v2Metadata   `protobuf:"bytes,5,opt,name=metadata,proto3"`
+}
+
+func (m *v2TimeSeries) Reset()         { *m = v2TimeSeries{} }
+func (m *v2TimeSeries) String() string { return proto.CompactTextString(m) }
+func (*v2TimeSeries) ProtoMessage()    {}
+
+type v2Sample struct {
+	Value     float64 `protobuf:"fixed64,1,opt,name=value,proto3"`
+	Timestamp int64   `protobuf:"varint,2,opt,name=timestamp,proto3"`
+}
+
+func (m *v2Sample) Reset()         { *m = v2Sample{} }
+func (m *v2Sample) String() string { return proto.CompactTextString(m) }
+func (*v2Sample) ProtoMessage()    {}
+
+type v2Exemplar struct {
+	LabelsRefs []uint32 `protobuf:"varint,1,rep,packed,name=labels_refs,proto3"`
+	Value      float64  `protobuf:"fixed64,2,opt,name=value,proto3"`
+	Timestamp  int64    `protobuf:"varint,3,opt,name=timestamp,proto3"`
+}
+
+func (m *v2Exemplar) Reset()         { *m = v2Exemplar{} }
+func (m *v2Exemplar) String() string { return proto.CompactTextString(m) }
+func (*v2Exemplar) ProtoMessage()    {}
+
+type v2Metadata struct {
+	Type    int32  `protobuf:"varint,1,opt,name=type,proto3"`
+	HelpRef uint32 `protobuf:"varint,3,opt,name=help_ref,proto3"`
+	UnitRef uint32 `protobuf:"varint,4,opt,name=unit_ref,proto3"`
+}
+
+func (m *v2Metadata) Reset()         { *m = v2Metadata{} }
+func (m *v2Metadata) String() string { return proto.CompactTextString(m) }
+func (*v2Metadata) ProtoMessage()    {}
+
+// resolve returns the labels the references point to in the symbol table.
+func resolve(t *testing.T, symbols []string, refs []uint32) []prompb.Label {
+	t.Helper()
+
+	if len(refs)%2 != 0 {
+		t.Fatalf("expected an even number of label references, got %d", len(refs))
+	}
+
+	lset := make([]prompb.Label, 0, len(refs)/2)
+
+	for i := 0; i < len(refs); i += 2 {
+		if int(refs[i]) >= len(symbols) || int(refs[i+1]) >= len(symbols) {
+			t.Fatalf("label references %v out of range of %d symbols", refs[i:i+2], len(symbols))
+		}
+
+		lset = append(lset, prompb.Label{Name: symbols[refs[i]], Value: symbols[refs[i+1]]})
+	}
+
+	return lset
+}
+
+func TestMarshalV2(t *testing.T) {
+	w, err := newWorkload([]prompb.Label{
+		{Name: "__name__", Value: "up"},
+		{Name: "instance", Value: "up-{{.Index}}"},
+	}, 3, seriesTypeGauge)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w.exemplars = true
+	w.metadata = &metadataConfig{Enabled: true, Help: "Heartbeat.", Unit: "milliseconds"}
+
+	sets, err := w.labelSets()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wreq := generate(sets, w)
+
+	b, err := wreq.MarshalV2()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var req v2Request
+	if err := proto.Unmarshal(b, &req); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(req.Symbols) == 0 || req.Symbols[0] != "" {
+		t.Fatalf("expected the first symbol to be empty, got %q", req.Symbols)
+	}
+
+	if len(req.Timeseries) != len(wreq.Timeseries) {
+		t.Fatalf("expected %d series, got %d", len(wreq.Timeseries), len(req.Timeseries))
+	}
+
+	for i, ts := range req.Timeseries {
+		expected := wreq.Timeseries[i]
+
+		if lset := resolve(t, req.Symbols, ts.LabelsRefs); !reflect.DeepEqual(lset, expected.Labels) {
+			t.Errorf("series %d: expected labels %v, got %v", i, expected.Labels, lset)
+		}
+
+		if len(ts.Samples) != 1 || ts.Samples[0].Value != expected.Samples[0].Value || ts.Samples[0].Timestamp != expected.Samples[0].Timestamp {
+			t.Errorf("series %d: expected samples %v, got %v", i, expected.Samples, ts.Samples)
+		}
+
+		e := wreq.Exemplars[i][0]
+		if len(ts.Exemplars) != 1 || ts.Exemplars[0].Value != e.Value || ts.Exemplars[0].Timestamp != e.Timestamp ||
+			!reflect.DeepEqual(resolve(t, req.Symbols, ts.Exemplars[0].LabelsRefs), e.Labels) {
+			t.Errorf("series %d: expected exemplar %v, got %v", i, e, ts.Exemplars)
+		}
+
+		if ts.Metadata == nil {
+			t.Fatalf("series %d: metadata missing", i)
+		}
+
+		if ts.Metadata.Type != 2 || req.Symbols[ts.Metadata.HelpRef] != "Heartbeat." || req.Symbols[ts.Metadata.UnitRef] != "milliseconds" {
+			t.Errorf("series %d: expected gauge metadata, got type %d help %q unit %q", i,
+				ts.Metadata.Type, req.Symbols[ts.Metadata.HelpRef], req.Symbols[ts.Metadata.UnitRef])
+		}
+	}
+}
+
+func TestVerifyWritten(t *testing.T) {
+	wreq := &writeRequest{
+		Timeseries: []prompb.TimeSeries{
+			{Samples: []prompb.Sample{{Value: 1}}},
+			{Samples: []prompb.Sample{{Value: 2}}},
+		},
+		Exemplars: map[int][]exemplar{0: {{Value: 1}}},
+	}
+
+	for _, tc := range []struct {
+		name    string
+		header  http.Header
+		invalid bool
+	}{
+		{
+			name:   "all written",
+			header: http.Header{samplesWrittenHeader: {"2"}, exemplarsWrittenHeader: {"1"}},
+		},
+		{
+			name:   "no histograms written",
+			header: http.Header{samplesWrittenHeader: {"2"}, histogramsWrittenHeader: {"0"}, exemplarsWrittenHeader: {"1"}},
+		},
+		{
+			name:    "samples header missing",
+			header:  http.Header{exemplarsWrittenHeader: {"1"}},
+			invalid: true,
+		},
+		{
+			name:    "samples not written",
+			header:  http.Header{samplesWrittenHeader: {"1"}, exemplarsWrittenHeader: {"1"}},
+			invalid: true,
+		},
+		{
+			name:    "exemplars not written",
+			header:  http.Header{samplesWrittenHeader: {"2"}, exemplarsWrittenHeader: {"0"}},
+			invalid: true,
+		},
+		{
+			name:    "invalid header",
+			header:  http.Header{samplesWrittenHeader: {"two"}, exemplarsWrittenHeader: {"1"}},
+			invalid: true,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := verifyWritten(tc.header, wreq)
+			if tc.invalid && err == nil {
+				t.Error("expected an error")
+			}
+
+			if !tc.invalid && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestWriteV2Fallback(t *testing.T) {
+	var versions []string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		versions = append(versions, r.Header.Get(remoteWriteVersionHeader))
+
+		if r.Header.Get("Content-Type") == remoteWriteContentTypeV2 {
+			w.WriteHeader(http.StatusUnsupportedMediaType)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	endpoint, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		wreq = &writeRequest{Timeseries: []prompb.TimeSeries{{
+			Labels:  []prompb.Label{{Name: "__name__", Value: "up"}},
+			Samples: []prompb.Sample{{Value: 1, Timestamp: 1}},
+		}}}
+		c = compressor{
+			compression:  compressionSnappy,
+			uncompressed: prometheus.NewHistogram(prometheus.HistogramOpts{Name: "uncompressed"}),
+			compressed:   prometheus.NewHistogram(prometheus.HistogramOpts{Name: "compressed"}),
+		}
+		v1        = newEndpointSet()
+		fallbacks = prometheus.NewCounter(prometheus.CounterOpts{Name: "fallbacks"})
+	)
+
+	// Only the first request is sent as remote-write 2.0, later ones go to the endpoint as remote-write 1.0 right away.
+	for i := 0; i < 2; i++ {
+		if err := writeV2(context.Background(), http.DefaultTransport, endpoint, wreq, c, v1, fallbacks, log.NewNopLogger()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if expected := []string{remoteWriteVersion2, remoteWriteVersion1, remoteWriteVersion1}; !reflect.DeepEqual(versions, expected) {
+		t.Errorf("expected requests of versions %v, got %v", expected, versions)
+	}
+
+	m := &dto.Metric{}
+	if err := fallbacks.Write(m); err != nil {
+		t.Fatal(err)
+	}
+
+	if v := m.GetCounter().GetValue(); v != 1 {
+		t.Errorf("expected 1 fallback, got %v", v)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Classes of remote-write responses, exposed as the class label of up_remote_writes_total.
+const (
+	class2xx          = "2xx"
+	class4xx          = "4xx"
+	class429          = "429"
+	class5xx          = "5xx"
+	classNetworkError = "network_error"
+	classTimeout      = "timeout"
+	// classOther is used for failures not caused by the response, e.g. when the request could not be built.
+	classOther = "other"
+)
+
+// maxErrorBodySize is the maximum number of bytes of a response body included in errors.
+const maxErrorBodySize = 1024
+
+// statusError is returned for responses with a non-2xx status code.
+type statusError struct {
+	code   int
+	status string
+	body   string
+}
+
+func (e statusError) Error() string {
+	if e.body == "" {
+		return fmt.Sprintf("server returned HTTP status %s", e.status)
+	}
+
+	return fmt.Sprintf("server returned HTTP status %s: %s", e.status, e.body)
+}
+
+// newStatusError creates a statusError holding the beginning of the response body.
+func newStatusError(res *http.Response) statusError {
+	b, _ := ioutil.ReadAll(io.LimitReader(res.Body, maxErrorBodySize))
+
+	return statusError{
+		code:   res.StatusCode,
+		status: res.Status,
+		body:   strings.TrimSpace(string(b)),
+	}
+}
+
+// classify returns the class of the response the error was caused by.
+func classify(err error) string {
+	if err == nil {
+		return class2xx
+	}
+
+	var serr statusError
+	if errors.As(err, &serr) {
+		switch {
+		case serr.code == http.StatusTooManyRequests:
+			return class429
+		case serr.code/100 == 5:
+			return class5xx
+		default:
+			return class4xx
+		}
+	}
+
+	var aerr authError
+	if errors.As(err, &aerr) {
+		return classOther
+	}
+
+	var nerr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &nerr) && nerr.Timeout()) {
+		return classTimeout
+	}
+
+	var rerr recoverableError
+	if errors.As(err, &rerr) {
+		return classNetworkError
+	}
+
+	return classOther
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+)
+
+// retryConfig configures the retrying of failed remote-write requests.
+// Its semantics mirror the Prometheus remote-write queue manager.
+type retryConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	MinBackoff time.Duration `yaml:"min_backoff"`
+	MaxBackoff time.Duration `yaml:"max_backoff"`
+}
+
+// register registers the flags configuring retries.
+func (c *retryConfig) register(fs *flag.FlagSet) {
+	fs.BoolVar(&c.Enabled, "write-retry", false,
+		"Retry remote-write requests failing with a 5xx or 429 status or a network error until the end of the period.")
+	fs.DurationVar(&c.MinBackoff, "write-retry-min-backoff", 30*time.Millisecond,
+		"The initial time to wait before retrying a failed remote-write request. Doubled on every retry.")
+	fs.DurationVar(&c.MaxBackoff, "write-retry-max-backoff", 100*time.Millisecond,
+		"The maximum time to wait before retrying a failed remote-write request.")
+}
+
+// recoverableError is returned for failed requests that are worth retrying,
+// e.g. on 5xx and 429 responses or when the request could not be sent at all.
+type recoverableError struct {
+	error
+	retryAfter time.Duration
+}
+
+func (e recoverableError) Unwrap() error {
+	return e.error
+}
+
+// retryAfter parses the value of a Retry-After header, either in seconds or as an HTTP date.
+// It returns 0 if the value is empty or cannot be parsed.
+func retryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+
+	if s, err := strconv.Atoi(v); err == nil {
+		if s < 0 {
+			return 0
+		}
+
+		return time.Duration(s) * time.Second
+	}
+
+	if t, err := http.ParseTime(v); err == nil {
+		return time.Until(t)
+	}
+
+	return 0
+}
+
+// writeWithRetry executes the request and, if retries are enabled, retries it on recoverable errors
+// with an exponential backoff until it succeeds or the deadline of the context would be exceeded.
+// A Retry-After duration returned by the server takes precedence over the backoff.
+// Requests are only counted as dropped once their retries run out.
+func writeWithRetry(ctx context.Context, cfg retryConfig, m metrics, tenant string, l log.Logger, f func(ctx context.Context) error) error {
+	backoff := cfg.MinBackoff
+
+	for attempt := 0; ; attempt++ {
+		err := f(ctx)
+		if err == nil {
+			if attempt > 0 {
+				m.remoteWriteRecovered.WithLabelValues(tenant).Inc()
+			}
+
+			return nil
+		}
+
+		var rerr recoverableError
+		if !cfg.Enabled || !errors.As(err, &rerr) {
+			return err
+		}
+
+		wait := backoff
+		if rerr.retryAfter > 0 {
+			wait = rerr.retryAfter
+		}
+
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			m.remoteWriteDropped.WithLabelValues(tenant).Inc()
+			return errors.Wrapf(err, "giving up after %d retries, next retry would exceed the deadline", attempt)
+		}
+
+		level.Debug(l).Log("msg", "retrying failed request", "attempt", attempt+1, "backoff", wait, "err", err)
+		m.remoteWriteRetries.WithLabelValues(tenant).Inc()
+
+		select {
+		case <-ctx.Done():
+			m.remoteWriteDropped.WithLabelValues(tenant).Inc()
+			return errors.Wrapf(err, "giving up after %d retries", attempt)
+		case <-time.After(wait):
+		}
+
+		backoff *= 2
+		if backoff > cfg.MaxBackoff {
+			backoff = cfg.MaxBackoff
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestWriteWithRetryDropped(t *testing.T) {
+	var (
+		enabled     = retryConfig{Enabled: true, MinBackoff: time.Millisecond, MaxBackoff: time.Millisecond}
+		recoverable = recoverableError{error: errors.New("unavailable")}
+	)
+
+	for _, tc := range []struct {
+		name string
+		cfg  retryConfig
+		// failures is the number of attempts failing with err before the request succeeds.
+		failures                    int
+		err                         error
+		retries, recovered, dropped float64
+	}{
+		{name: "retries disabled", failures: 1, err: recoverable},
+		{name: "not recoverable", cfg: enabled, failures: 1, err: errors.New("bad request")},
+		{name: "recovered", cfg: enabled, failures: 2, err: recoverable, retries: 2, recovered: 1},
+		{name: "retries run out", cfg: enabled, failures: -1, err: recoverable, dropped: 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m := registerMetrics(prometheus.NewRegistry())
+
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+
+			var attempts int
+
+			err := writeWithRetry(ctx, tc.cfg, m, "a", log.NewNopLogger(), func(context.Context) error {
+				attempts++
+				if tc.failures < 0 || attempts <= tc.failures {
+					return tc.err
+				}
+
+				return nil
+			})
+			if (err == nil) != (tc.recovered > 0) {
+				t.Errorf("unexpected error: %v", err)
+			}
+
+			if v := counterValue(m.remoteWriteRetries.WithLabelValues("a")); tc.failures >= 0 && v != tc.retries {
+				t.Errorf("expected %v retries, got %v", tc.retries, v)
+			}
+
+			if v := counterValue(m.remoteWriteRecovered.WithLabelValues("a")); v != tc.recovered {
+				t.Errorf("expected %v recovered requests, got %v", tc.recovered, v)
+			}
+
+			if v := counterValue(m.remoteWriteDropped.WithLabelValues("a")); v != tc.dropped {
+				t.Errorf("expected %v dropped requests, got %v", tc.dropped, v)
+			}
+		})
+	}
+}
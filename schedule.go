@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+)
+
+// schedule tracks when a query is run next.
+type schedule struct {
+	// due is the time the query is due without jitter, so jitter does not make the query drift.
+	due time.Time
+	// next is the due time with jitter applied.
+	next time.Time
+}
+
+// scheduleQueries runs the custom queries of the tenant with the given index at their intervals until the context is done.
+// At most concurrency queries are run at the same time; further due queries wait for a running one to finish.
+// Queries are read from the live options before every run, so reloaded queries are picked up.
+func scheduleQueries(ctx context.Context, l log.Logger, live *liveOptions, i int, m metrics, concurrency int) {
+	var (
+		wg        sync.WaitGroup
+		pool      = make(chan struct{}, concurrency)
+		schedules = map[string]schedule{}
+	)
+
+	// Wait for running queries, so they are accounted for in the results.
+	defer wg.Wait()
+
+	for {
+		opts := live.get()
+		tn := opts.Tenants[i]
+		now := time.Now()
+		// Check for new queries at least once per period.
+		wake := now.Add(opts.Period)
+
+		for _, q := range opts.Queries {
+			s, ok := schedules[q.Name]
+			if !ok {
+				s = schedule{due: now, next: now.Add(jitter(q.Jitter))}
+			}
+
+			if !s.next.After(now) {
+				select {
+				case pool <- struct{}{}:
+				case <-ctx.Done():
+					return
+				}
+
+				wg.Add(1)
+
+				go func(q querySpec) {
+					defer func() {
+						<-pool
+						wg.Done()
+					}()
+
+					// Runs in flight at shutdown are bounded by the timeout of the query only, so they finish and are waited for above.
+					qCtx, cancel := context.WithTimeout(context.Background(), q.Timeout)
+					defer cancel()
+
+					runCustomQuery(qCtx, l, tn, q, m)
+				}(q)
+
+				s.due = s.due.Add(q.Interval)
+				// Skip runs that were missed, e.g. because all workers were busy.
+				if s.due.Before(now) {
+					s.due = now.Add(q.Interval)
+				}
+
+				s.next = s.due.Add(jitter(q.Jitter))
+			}
+
+			schedules[q.Name] = s
+
+			if s.next.Before(wake) {
+				wake = s.next
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(wake)):
+		}
+	}
+}
+
+// jitter returns a random duration between 0 and max.
+func jitter(max time.Duration) time.Duration {
+	if max <= 0 {
+		return 0
+	}
+
+	return time.Duration(rand.Int63n(int64(max))) //nolint:gosec
+}
+
+// latencySLO requires a ratio of the runs of a query to finish within a target duration.
+// Runs that failed to get a response are not taken into account.
+type latencySLO struct {
+	Target time.Duration `yaml:"target"`
+	// Threshold is the ratio of runs that need to finish within the target. Defaults to 1.
+	Threshold float64 `yaml:"threshold"`
+}
+
+func (q querySpec) validateThresholds() error {
+	if q.Threshold != nil && (*q.Threshold < 0 || *q.Threshold > 1) {
+		return errors.Errorf("threshold is invalid: %v is not between 0 and 1", *q.Threshold)
+	}
+
+	if q.LatencySLO == nil {
+		return nil
+	}
+
+	if q.LatencySLO.Target <= 0 {
+		return errors.New("latency_slo is invalid: target must be positive")
+	}
+
+	if q.LatencySLO.Threshold < 0 || q.LatencySLO.Threshold > 1 {
+		return errors.Errorf("latency_slo is invalid: threshold %v is not between 0 and 1", q.LatencySLO.Threshold)
+	}
+
+	return nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v2"
+)
+
+// tenant is probed with its own endpoints, credentials and success threshold.
+// All metrics of a tenant are labelled with its name.
+type tenant struct {
+	Name             string
+	WriteEndpoint    *url.URL
+	ReadEndpoint     *url.URL
+	WriteProtocol    string
+	ReadProtocol     string
+	WriteAuth        authConfig
+	ReadAuth         authConfig
+	WriteTransport   http.RoundTripper
+	ReadTransport    http.RoundTripper
+	SuccessThreshold float64
+}
+
+func (t tenant) logger(l log.Logger) log.Logger {
+	if t.Name == "" {
+		return l
+	}
+
+	return log.With(l, "tenant", t.Name)
+}
+
+func (t tenant) collectors() []prometheus.Collector {
+	return append(t.WriteAuth.collectors(), t.ReadAuth.collectors()...)
+}
+
+// tenantSpec specifies a tenant, either from flags or from the tenants file.
+type tenantSpec struct {
+	Name string `yaml:"name"`
+	// ID is the value of the tenant header. Defaults to the name of the tenant.
+	ID string `yaml:"id"`
+	// Header is the name of the header identifying the tenant, e.g. THANOS-TENANT or X-Scope-OrgID.
+	Header        string   `yaml:"header"`
+	WriteEndpoint string   `yaml:"endpoint_write"`
+	ReadEndpoint  string   `yaml:"endpoint_read"`
+	WriteProtocol string   `yaml:"endpoint_write_protocol"`
+	ReadProtocol  string   `yaml:"endpoint_read_protocol"`
+	Threshold     *float64 `yaml:"threshold"`
+	Auth          authSpec `yaml:"auth"`
+	ReadAuth      authSpec `yaml:"read_auth"`
+}
+
+type tenantsFile struct {
+	Tenants []tenantSpec `yaml:"tenants"`
+}
+
+// build creates the tenant from the spec. The endpoints must already be parsed.
+// The endpoints are requested with the given transport, OIDC tokens are fetched with the issuer transport.
+func (s tenantSpec) build(l log.Logger, write, read *url.URL, transport, issuer http.RoundTripper) tenant {
+	auth := s.Auth
+
+	if s.Header != "" {
+		id := s.ID
+		if id == "" {
+			id = s.Name
+		}
+
+		auth.Headers = headerArg{}
+		for name, vs := range s.Auth.Headers {
+			auth.Headers[name] = vs
+		}
+
+		http.Header(auth.Headers).Set(s.Header, id)
+	}
+
+	writeAuth := auth.build(l, authConfig{}, issuer)
+	readAuth := s.ReadAuth.build(l, writeAuth, issuer)
+
+	return tenant{
+		Name:             s.Name,
+		WriteEndpoint:    write,
+		ReadEndpoint:     read,
+		WriteProtocol:    s.WriteProtocol,
+		ReadProtocol:     s.ReadProtocol,
+		WriteAuth:        writeAuth,
+		ReadAuth:         readAuth,
+		WriteTransport:   newAuthRoundTripper(writeAuth, transport),
+		ReadTransport:    newAuthRoundTripper(readAuth, transport),
+		SuccessThreshold: *s.Threshold,
+	}
+}
+
+// readTenantsFile reads the specs of the tenants from the given file.
+func readTenantsFile(file string) ([]tenantSpec, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	tf := tenantsFile{}
+	if err := yaml.UnmarshalStrict(b, &tf); err != nil {
+		return nil, err
+	}
+
+	if tf.Tenants == nil {
+		tf.Tenants = []tenantSpec{}
+	}
+
+	return tf.Tenants, nil
+}
+
+// buildTenants validates the specs and creates the tenants from them.
+// Tenants use the header, protocols, threshold and credentials of the defaults unless they set their own.
+func buildTenants(l log.Logger, specs []tenantSpec, defaults tenantSpec, transport, issuer http.RoundTripper) ([]tenant, error) {
+	if len(specs) == 0 {
+		return nil, errors.New("no tenants configured")
+	}
+
+	var (
+		tenants = make([]tenant, 0, len(specs))
+		names   = map[string]struct{}{}
+	)
+
+	for i, s := range specs {
+		if s.Name == "" {
+			return nil, errors.Errorf("tenants[%d]: name is required", i)
+		}
+
+		if _, ok := names[s.Name]; ok {
+			return nil, errors.Errorf("tenant %q: name is not unique", s.Name)
+		}
+
+		names[s.Name] = struct{}{}
+
+		if s.Header == "" {
+			s.Header = defaults.Header
+		}
+
+		if s.WriteProtocol == "" {
+			s.WriteProtocol = defaults.WriteProtocol
+		}
+
+		if s.ReadProtocol == "" {
+			s.ReadProtocol = defaults.ReadProtocol
+		}
+
+		if s.Threshold == nil {
+			s.Threshold = defaults.Threshold
+		}
+
+		s.Auth = s.Auth.inherit(defaults.Auth)
+		s.ReadAuth = s.ReadAuth.inherit(defaults.ReadAuth)
+
+		write, read, err := s.validate()
+		if err != nil {
+			return nil, fmt.Errorf("tenant %q: %w", s.Name, err)
+		}
+
+		tenants = append(tenants, s.build(log.With(l, "tenant", s.Name), write, read, transport, issuer))
+	}
+
+	return tenants, nil
+}
+
+// validate validates the spec of a tenant from the tenants file and returns its parsed endpoints.
+func (s tenantSpec) validate() (*url.URL, *url.URL, error) {
+	var write, read *url.URL
+
+	if s.WriteEndpoint == "" && s.ReadEndpoint == "" {
+		return nil, nil, errors.New("at least one of endpoint_write and endpoint_read is required")
+	}
+
+	if s.WriteEndpoint != "" {
+		u, err := url.ParseRequestURI(s.WriteEndpoint)
+		if err != nil {
+			return nil, nil, fmt.Errorf("endpoint_write is invalid: %w", err)
+		}
+
+		write = u
+	}
+
+	if s.ReadEndpoint != "" {
+		u, err := url.ParseRequestURI(s.ReadEndpoint)
+		if err != nil {
+			return nil, nil, fmt.Errorf("endpoint_read is invalid: %w", err)
+		}
+
+		read = u
+	}
+
+	if !validWriteProtocol(s.WriteProtocol) {
+		return nil, nil, errors.Errorf("endpoint_write_protocol is invalid: unknown protocol %q", s.WriteProtocol)
+	}
+
+	if s.ReadProtocol != readProtocolQuery && s.ReadProtocol != readProtocolRemoteRead {
+		return nil, nil, errors.Errorf("endpoint_read_protocol is invalid: unknown protocol %q", s.ReadProtocol)
+	}
+
+	if *s.Threshold < 0 || *s.Threshold > 1 {
+		return nil, nil, errors.Errorf("threshold is invalid: %v is not between 0 and 1", *s.Threshold)
+	}
+
+	if err := s.Auth.validate(); err != nil {
+		return nil, nil, fmt.Errorf("auth is invalid: %w", err)
+	}
+
+	if err := s.ReadAuth.validate(); err != nil {
+		return nil, nil, fmt.Errorf("read_auth is invalid: %w", err)
+	}
+
+	return write, read, nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestBuildTenantsDefaults(t *testing.T) {
+	threshold := 0.5
+
+	defaults := tenantSpec{
+		Header:        "X-Scope-OrgID",
+		WriteProtocol: writeProtocolRemoteWrite,
+		ReadProtocol:  readProtocolQuery,
+		Threshold:     &threshold,
+		Auth:          authSpec{Token: "global", Headers: headerArg{"X-Source": {"flags"}}},
+	}
+
+	ts, err := buildTenants(log.NewNopLogger(), []tenantSpec{
+		{Name: "inherited", WriteEndpoint: "http://localhost/write"},
+		{
+			Name:          "own",
+			ID:            "1234",
+			Header:        "THANOS-TENANT",
+			WriteEndpoint: "http://localhost/write",
+			Auth:          authSpec{Username: "up", Password: "secret", Headers: headerArg{"X-Source": {"tenant"}}},
+		},
+	}, defaults, http.DefaultTransport, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		tenant   tenant
+		token    string
+		username string
+		headers  map[string]string
+	}{
+		{
+			tenant:  ts[0],
+			token:   "global",
+			headers: map[string]string{"X-Scope-OrgID": "inherited", "X-Source": "flags"},
+		},
+		{
+			tenant:   ts[1],
+			username: "up",
+			headers:  map[string]string{"THANOS-TENANT": "1234", "X-Source": "tenant"},
+		},
+	} {
+		t.Run(tc.tenant.Name, func(t *testing.T) {
+			token, err := tc.tenant.WriteAuth.Token.Get()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if token != tc.token {
+				t.Errorf("expected token %q, got %q", tc.token, token)
+			}
+
+			if tc.tenant.WriteAuth.Username != tc.username {
+				t.Errorf("expected username %q, got %q", tc.username, tc.tenant.WriteAuth.Username)
+			}
+
+			for name, v := range tc.headers {
+				if got := tc.tenant.WriteAuth.Headers.Get(name); got != v {
+					t.Errorf("expected header %s to be %q, got %q", name, v, got)
+				}
+			}
+
+			if tc.tenant.SuccessThreshold != threshold {
+				t.Errorf("expected threshold %v, got %v", threshold, tc.tenant.SuccessThreshold)
+			}
+		})
+	}
+}
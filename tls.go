@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+)
+
+// tlsConfig configures the TLS client used to talk to the write and read endpoints.
+type tlsConfig struct {
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// register registers the flags configuring the TLS client.
+func (c *tlsConfig) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.CAFile, "tls-ca-file", "",
+		"The file containing the CA certificates to verify the certificates of the write and read endpoints and the OIDC issuer with.")
+	fs.StringVar(&c.CertFile, "tls-cert-file", "",
+		"The file containing the client certificate to present to the write and read endpoints. Reloaded when it changes.")
+	fs.StringVar(&c.KeyFile, "tls-key-file", "",
+		"The file containing the private key of the client certificate. Reloaded when it changes.")
+	fs.StringVar(&c.ServerName, "tls-server-name", "",
+		"The server name used to verify the certificates of the write and read endpoints, if different from their host name.")
+	fs.BoolVar(&c.InsecureSkipVerify, "tls-insecure-skip-verify", false,
+		"Skip verifying the certificates of the write and read endpoints and the OIDC issuer. Insecure, use for testing only.")
+}
+
+func (c tlsConfig) enabled() bool {
+	return c.CAFile != "" || c.CertFile != "" || c.KeyFile != "" || c.ServerName != "" || c.InsecureSkipVerify
+}
+
+// issuer returns the TLS configuration to verify the OIDC issuer with.
+// Only the CA certificates and skipping verification apply to the issuer, the server name and the client certificate
+// are specific to the write and read endpoints.
+func (c tlsConfig) issuer() tlsConfig {
+	return tlsConfig{CAFile: c.CAFile, InsecureSkipVerify: c.InsecureSkipVerify}
+}
+
+func (c tlsConfig) validate() error {
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return errors.New("client certificate and key must be set together")
+	}
+
+	return nil
+}
+
+func (c tlsConfig) files() []string {
+	var fs []string
+
+	for _, f := range []string{c.CAFile, c.CertFile, c.KeyFile} {
+		if f != "" {
+			fs = append(fs, f)
+		}
+	}
+
+	return fs
+}
+
+// build reads the configured files and creates the crypto/tls configuration from them.
+func (c tlsConfig) build() (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify, //nolint:gosec
+	}
+
+	if c.CAFile != "" {
+		b, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "read CA file")
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, errors.Errorf("no certificates found in CA file %s", c.CAFile)
+		}
+
+		cfg.RootCAs = pool
+	}
+
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "load client certificate")
+		}
+
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+
+	return cfg, nil
+}
+
+// newTransport returns the round tripper to use for the given TLS configuration.
+// If no TLS option is set, the default transport is used.
+func newTransport(l log.Logger, c tlsConfig) (http.RoundTripper, error) {
+	if !c.enabled() {
+		return http.DefaultTransport, nil
+	}
+
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+
+	t := &reloadingTransport{l: l, cfg: c}
+	if err := t.reload(); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// tlsCheckInterval is the minimum time between checks of the TLS files for changes,
+// so requests do not stat them every time.
+const tlsCheckInterval = time.Second
+
+type fileState struct {
+	modTime time.Time
+	size    int64
+}
+
+// reloadingTransport is an http.RoundTripper with a TLS configuration built from files on disk.
+// Whenever one of the files changes, e.g. because certificates got rotated, the TLS configuration is rebuilt.
+// If rebuilding fails, e.g. while files are only partially written, the previous configuration keeps being used
+// until the files change again.
+type reloadingTransport struct {
+	l   log.Logger
+	cfg tlsConfig
+
+	mtx     sync.Mutex
+	t       *http.Transport
+	states  map[string]fileState
+	checked time.Time
+}
+
+func (t *reloadingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.mtx.Lock()
+
+	if now := time.Now(); now.Sub(t.checked) >= tlsCheckInterval && t.check(now) {
+		if err := t.reload(); err != nil {
+			level.Warn(t.l).Log("msg", "failed to reload TLS configuration, keep using previous one", "err", err)
+		} else {
+			level.Info(t.l).Log("msg", "reloaded TLS configuration")
+		}
+	}
+
+	rt := t.t
+	t.mtx.Unlock()
+
+	return rt.RoundTrip(req)
+}
+
+// CloseIdleConnections closes the idle connections of the current transport.
+func (t *reloadingTransport) CloseIdleConnections() {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	t.t.CloseIdleConnections()
+}
+
+// check records the time of the check and reports whether the files changed. It must be called with the lock held.
+func (t *reloadingTransport) check(now time.Time) bool {
+	t.checked = now
+	return t.changed()
+}
+
+func (t *reloadingTransport) changed() bool {
+	for _, f := range t.cfg.files() {
+		fi, err := os.Stat(f)
+		if err != nil {
+			continue
+		}
+
+		if s, ok := t.states[f]; !ok || s.modTime != fi.ModTime() || s.size != fi.Size() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// reload rebuilds the transport. It must be called with the lock held.
+// The state of the files is recorded even if rebuilding fails, so it is only attempted again once they change.
+func (t *reloadingTransport) reload() error {
+	t.states = make(map[string]fileState, len(t.cfg.files()))
+
+	for _, f := range t.cfg.files() {
+		// Missing files are not recorded, so they count as changed once they appear, and reported by build.
+		fi, err := os.Stat(f)
+		if err != nil {
+			continue
+		}
+
+		t.states[f] = fileState{modTime: fi.ModTime(), size: fi.Size()}
+	}
+
+	cfg, err := t.cfg.build()
+	if err != nil {
+		return err
+	}
+
+	nt := http.DefaultTransport.(*http.Transport).Clone()
+	nt.TLSClientConfig = cfg
+
+	if t.t != nil {
+		t.t.CloseIdleConnections()
+	}
+
+	t.t = nt
+
+	return nil
+}
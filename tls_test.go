@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+func TestReloadingTransport(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "up")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	var failures int
+
+	l := log.LoggerFunc(func(kvs ...interface{}) error {
+		for _, kv := range kvs {
+			if s, ok := kv.(string); ok && strings.HasPrefix(s, "failed to reload TLS configuration") {
+				failures++
+			}
+		}
+
+		return nil
+	})
+
+	rt, err := newTransport(l, tlsConfig{CAFile: ca})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func() {
+		t.Helper()
+
+		req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := rt.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp.Body.Close()
+	}
+
+	get()
+
+	// Files are only checked once per interval.
+	if err := ioutil.WriteFile(ca, []byte("invalid"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	get()
+
+	if failures != 0 {
+		t.Fatalf("expected the files not to be checked again within the interval, got %d failed reloads", failures)
+	}
+
+	// A failed reload keeps the previous configuration and is not retried until the files change again.
+	for i := 0; i < 3; i++ {
+		rt.(*reloadingTransport).checked = time.Time{}
+
+		get()
+	}
+
+	if failures != 1 {
+		t.Errorf("expected 1 failed reload, got %d", failures)
+	}
+}
@@ -1,8 +1,23 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
 	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/clientcredentials"
 )
 
 func NewNoOpTokenProvider() *StaticToken {
@@ -21,19 +36,240 @@ func (t *StaticToken) Get() (string, error) {
 	return t.token, nil
 }
 
+// tokenFileCheckInterval is the minimum time between checks of the token file for changes,
+// so requests do not stat it every time.
+const tokenFileCheckInterval = time.Second
+
+// FileToken reads the token from a file. The token is cached in memory and only read again once the
+// modification time or size of the file changes. If the file cannot be read, e.g. because it is briefly
+// missing during a Kubernetes secret rotation, the last successfully read token keeps being used.
 type FileToken struct {
+	l    log.Logger
 	file string
+
+	mtx     sync.Mutex
+	token   string
+	loaded  bool
+	modTime time.Time
+	size    int64
+	checked time.Time
+	err     error
+
+	reloads        prometheus.Counter
+	reloadFailures prometheus.Counter
 }
 
-func NewFileToken(file string) *FileToken {
-	return &FileToken{file: file}
+func NewFileToken(l log.Logger, file string) *FileToken {
+	return &FileToken{
+		l:    l,
+		file: file,
+		reloads: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "up_token_file_reloads_total",
+			Help:        "Total number of times the token was read from the token file.",
+			ConstLabels: prometheus.Labels{"file": file},
+		}),
+		reloadFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "up_token_file_reload_failures_total",
+			Help:        "Total number of times the token could not be read from the token file.",
+			ConstLabels: prometheus.Labels{"file": file},
+		}),
+	}
 }
 
+// Get returns the token, checking the file for changes at most once per interval.
+// Failed checks are counted and logged once, not on every request until the next check.
 func (t *FileToken) Get() (string, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if now := time.Now(); now.Sub(t.checked) >= tokenFileCheckInterval {
+		t.checked = now
+
+		t.err = t.reload()
+		if t.err != nil {
+			t.reloadFailures.Inc()
+
+			if t.loaded {
+				level.Warn(t.l).Log("msg", "failed to reload token file, using last read token", "file", t.file, "err", t.err)
+			}
+		}
+	}
+
+	if !t.loaded {
+		return "", t.err
+	}
+
+	return t.token, nil
+}
+
+// reload reads the token file if it changed since it was last read. It must be called with the lock held.
+func (t *FileToken) reload() error {
+	fi, err := os.Stat(t.file)
+	if err != nil {
+		return errors.Wrap(err, "stat token file")
+	}
+
+	if t.loaded && fi.ModTime().Equal(t.modTime) && fi.Size() == t.size {
+		return nil
+	}
+
 	b, err := ioutil.ReadFile(t.file)
+	if err != nil {
+		return errors.Wrap(err, "read token file")
+	}
+
+	token := strings.TrimSpace(string(b))
+	if token == "" {
+		return errors.New("token file is empty")
+	}
+
+	t.token = token
+	t.loaded = true
+	t.modTime = fi.ModTime()
+	t.size = fi.Size()
+	t.reloads.Inc()
+
+	level.Debug(t.l).Log("msg", "read token from file", "file", t.file)
+
+	return nil
+}
+
+// Describe implements prometheus.Collector.
+func (t *FileToken) Describe(ch chan<- *prometheus.Desc) {
+	t.reloads.Describe(ch)
+	t.reloadFailures.Describe(ch)
+}
+
+// Collect implements prometheus.Collector.
+func (t *FileToken) Collect(ch chan<- prometheus.Metric) {
+	t.reloads.Collect(ch)
+	t.reloadFailures.Collect(ch)
+}
+
+// oidcConfig configures fetching tokens with the OAuth2 client-credentials flow.
+type oidcConfig struct {
+	IssuerURL    string   `yaml:"issuer_url"`
+	TokenURL     string   `yaml:"token_url"`
+	ClientID     string   `yaml:"client_id"`
+	ClientSecret string   `yaml:"client_secret"`
+	Audience     string   `yaml:"audience"`
+	Scopes       []string `yaml:"scopes"`
+}
+
+func (c oidcConfig) enabled() bool {
+	return c.IssuerURL != "" || c.TokenURL != ""
+}
+
+// register registers the flags configuring the OIDC client.
+func (c *oidcConfig) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.IssuerURL, "oidc-issuer-url", "",
+		"The OIDC issuer to fetch bearer tokens from with the client-credentials flow. Used if neither --token nor --token-file is set.")
+	fs.StringVar(&c.TokenURL, "oidc-token-url", "",
+		"The OAuth2 token endpoint to fetch bearer tokens from. Discovered from --oidc-issuer-url if not set.")
+	fs.StringVar(&c.ClientID, "oidc-client-id", "", "The OAuth2 client ID used to fetch bearer tokens.")
+	fs.StringVar(&c.ClientSecret, "oidc-client-secret", "", "The OAuth2 client secret used to fetch bearer tokens.")
+	fs.StringVar(&c.Audience, "oidc-audience", "", "The audience to request bearer tokens for.")
+	fs.Var((*scopesArg)(&c.Scopes), "oidc-scopes", "A comma-separated list of scopes to request bearer tokens with.")
+}
+
+type scopesArg []string
+
+func (sa *scopesArg) String() string {
+	return strings.Join(*sa, ",")
+}
+
+func (sa *scopesArg) Set(v string) error {
+	*sa = strings.Split(v, ",")
+
+	return nil
+}
+
+// OIDCToken fetches tokens from the token endpoint of an OAuth2/OIDC issuer using the client-credentials flow.
+// Tokens are cached and transparently refreshed shortly before they expire.
+// If only the issuer URL is set, the token endpoint is discovered from the issuer's OpenID configuration.
+type OIDCToken struct {
+	cfg    oidcConfig
+	client *http.Client
+
+	mtx sync.Mutex
+	ts  oauth2.TokenSource
+}
+
+func NewOIDCToken(cfg oidcConfig, transport http.RoundTripper) *OIDCToken {
+	return &OIDCToken{cfg: cfg, client: &http.Client{Timeout: 30 * time.Second, Transport: transport}}
+}
+
+func (t *OIDCToken) Get() (string, error) {
+	ts, err := t.tokenSource()
 	if err != nil {
 		return "", err
 	}
 
-	return strings.TrimSpace(string(b)), nil
+	tok, err := ts.Token()
+	if err != nil {
+		return "", errors.Wrap(err, "fetching OAuth2 token")
+	}
+
+	return tok.AccessToken, nil
+}
+
+func (t *OIDCToken) tokenSource() (oauth2.TokenSource, error) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if t.ts != nil {
+		return t.ts, nil
+	}
+
+	tokenURL := t.cfg.TokenURL
+	if tokenURL == "" {
+		var err error
+
+		tokenURL, err = t.discoverTokenURL()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	cc := clientcredentials.Config{
+		ClientID:     t.cfg.ClientID,
+		ClientSecret: t.cfg.ClientSecret,
+		TokenURL:     tokenURL,
+		Scopes:       t.cfg.Scopes,
+	}
+
+	if t.cfg.Audience != "" {
+		cc.EndpointParams = url.Values{"audience": []string{t.cfg.Audience}}
+	}
+
+	t.ts = cc.TokenSource(context.WithValue(context.Background(), oauth2.HTTPClient, t.client))
+
+	return t.ts, nil
+}
+
+func (t *OIDCToken) discoverTokenURL() (string, error) {
+	res, err := t.client.Get(strings.TrimSuffix(t.cfg.IssuerURL, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return "", errors.Wrap(err, "fetching OpenID configuration")
+	}
+
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", errors.Errorf("fetching OpenID configuration: unexpected status %s", res.Status)
+	}
+
+	var oc struct {
+		TokenEndpoint string `json:"token_endpoint"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&oc); err != nil {
+		return "", errors.Wrap(err, "decoding OpenID configuration")
+	}
+
+	if oc.TokenEndpoint == "" {
+		return "", errors.New("OpenID configuration has no token endpoint")
+	}
+
+	return oc.TokenEndpoint, nil
 }
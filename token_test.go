@@ -0,0 +1,218 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+)
+
+// writeClientCertificate writes a self-signed client certificate and its key to the given directory.
+func writeClientCertificate(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "up"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "up"}}, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	kb, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile = filepath.Join(dir, "client.pem"), filepath.Join(dir, "client-key.pem")
+
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kb}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certFile, keyFile
+}
+
+func TestOIDCTokenTLS(t *testing.T) {
+	var (
+		issuer      *httptest.Server
+		clientCerts int
+	)
+
+	issuer = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		clientCerts += len(r.TLS.PeerCertificates)
+
+		w.Header().Set("Content-Type", "application/json")
+
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			_ = json.NewEncoder(w).Encode(map[string]string{"token_endpoint": issuer.URL + "/token"})
+		case "/token":
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "secret", "token_type": "bearer", "expires_in": 3600})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	issuer.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	issuer.StartTLS()
+
+	defer issuer.Close()
+
+	dir, err := ioutil.TempDir("", "up")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The issuer is only trusted through the CA file, like an issuer behind a private CA.
+	ca := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: issuer.Certificate().Raw}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// The server name and the client certificate are only meant for the endpoints.
+	cert, key := writeClientCertificate(t, dir)
+	cfg := tlsConfig{CAFile: ca, CertFile: cert, KeyFile: key, ServerName: "endpoint.invalid"}
+
+	oidc := oidcConfig{IssuerURL: issuer.URL, ClientID: "up", ClientSecret: "up"}
+
+	transport, err := newTransport(log.NewNopLogger(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewOIDCToken(oidc, transport).Get(); err == nil {
+		t.Fatal("expected the issuer certificate not to be valid for the server name of the endpoints")
+	}
+
+	issuerTransport, err := newTransport(log.NewNopLogger(), cfg.issuer())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tok, err := NewOIDCToken(oidc, issuerTransport).Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tok != "secret" {
+		t.Errorf("expected token %q, got %q", "secret", tok)
+	}
+
+	if clientCerts != 0 {
+		t.Errorf("expected no client certificate to be sent to the issuer, got %d", clientCerts)
+	}
+}
+
+func TestFileToken(t *testing.T) {
+	dir, err := ioutil.TempDir("", "up")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "token")
+
+	var warnings int
+
+	l := log.LoggerFunc(func(kvs ...interface{}) error {
+		for _, kv := range kvs {
+			if s, ok := kv.(string); ok && strings.HasPrefix(s, "failed to reload token file") {
+				warnings++
+			}
+		}
+
+		return nil
+	})
+
+	ft := NewFileToken(l, file)
+
+	write := func(token string) {
+		t.Helper()
+
+		if err := ioutil.WriteFile(file, []byte(token+"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// get returns the token, checking the file again if check is set instead of waiting for the interval.
+	get := func(check bool, expected string, reloads, failures float64) {
+		t.Helper()
+
+		if check {
+			ft.checked = time.Time{}
+		}
+
+		token, err := ft.Get()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if token != expected {
+			t.Errorf("expected token %q, got %q", expected, token)
+		}
+
+		if v := counterValue(ft.reloads); v != reloads {
+			t.Errorf("expected %v reloads, got %v", reloads, v)
+		}
+
+		if v := counterValue(ft.reloadFailures); v != failures {
+			t.Errorf("expected %v reload failures, got %v", failures, v)
+		}
+	}
+
+	if _, err := ft.Get(); err == nil {
+		t.Fatal("expected an error before the token file exists")
+	}
+
+	write("first")
+	get(true, "first", 1, 1)
+
+	// The file is only checked once per interval.
+	write("second")
+	get(false, "first", 1, 1)
+	get(true, "second", 2, 1)
+
+	// The last read token keeps being used while the file is missing, e.g. during a rotation.
+	if err := os.Remove(file); err != nil {
+		t.Fatal(err)
+	}
+
+	get(true, "second", 2, 2)
+	get(false, "second", 2, 2)
+	get(true, "second", 2, 3)
+
+	if warnings != 2 {
+		t.Errorf("expected 2 warnings, got %d", warnings)
+	}
+
+	write("third")
+	get(true, "third", 3, 3)
+}
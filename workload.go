@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// seriesData is the data label value templates are executed with.
+type seriesData struct {
+	// Index is the position of the series in the request, starting at 0.
+	Index int
+}
+
+type labelTemplate struct {
+	name      string
+	value     string
+	tmpl      *template.Template
+	templated bool
+}
+
+// workload describes the set of series written on every remote-write request.
+type workload struct {
+	series int
+	labels []labelTemplate
+	// typ is the type of the written series, e.g. gauge or native-histogram.
+	typ string
+	// metadata is the metadata sent for the written metrics, if any.
+	metadata *metadataConfig
+	// exemplars reports whether exemplars are attached to the written series.
+	exemplars bool
+	// churn rotates a label of some of the series, if set.
+	churn *churnConfig
+}
+
+func newWorkload(labels []prompb.Label, series int, typ string) (*workload, error) {
+	if series < 1 {
+		return nil, errors.Errorf("number of series must be at least 1, got %d", series)
+	}
+
+	w := &workload{series: series, labels: make([]labelTemplate, 0, len(labels)), typ: typ}
+
+	for _, l := range labels {
+		t, err := template.New(l.Name).Option("missingkey=error").Parse(l.Value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse template of label %s", l.Name)
+		}
+
+		w.labels = append(w.labels, labelTemplate{
+			name:      l.Name,
+			value:     l.Value,
+			tmpl:      t,
+			templated: strings.Contains(l.Value, "{{"),
+		})
+	}
+
+	if series > 1 && !w.templated() {
+		return nil, errors.New("writing more than one series requires at least one templated label value, e.g. instance=\"up-{{.Index}}\"")
+	}
+
+	// Render once to surface template execution errors early.
+	if _, err := w.labelSets(); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (w *workload) templated() bool {
+	for _, l := range w.labels {
+		if l.templated {
+			return true
+		}
+	}
+
+	return false
+}
+
+// labelSets renders the label sets of all series of the workload at the current time.
+func (w *workload) labelSets() ([][]prompb.Label, error) {
+	return w.labelSetsAt(w.generation(time.Now()))
+}
+
+// labelSetsAt renders the label sets of all series of the workload in the given generation of churn.
+func (w *workload) labelSetsAt(generation int64) ([][]prompb.Label, error) {
+	sets := make([][]prompb.Label, w.series)
+
+	for i := 0; i < w.series; i++ {
+		lset, err := w.labelSet(i)
+		if err != nil {
+			return nil, err
+		}
+
+		if w.churn != nil && i < w.churn.rotated(w.series) {
+			w.churn.rotate(lset, generation)
+		}
+
+		sets[i] = lset
+	}
+
+	return sets, nil
+}
+
+// generation returns the generation of churn at the given time, which is always 0 without churn.
+func (w *workload) generation(ts time.Time) int64 {
+	if w.churn == nil {
+		return 0
+	}
+
+	return w.churn.generation(ts)
+}
+
+// labelSet renders the label set of the series with the given index.
+// The index may exceed the number of series of the workload, e.g. to render the series replacing others under churn.
+func (w *workload) labelSet(index int) ([]prompb.Label, error) {
+	var (
+		buf  bytes.Buffer
+		lset = make([]prompb.Label, len(w.labels))
+	)
+
+	for j, l := range w.labels {
+		if !l.templated {
+			lset[j] = prompb.Label{Name: l.name, Value: l.value}
+			continue
+		}
+
+		buf.Reset()
+
+		if err := l.tmpl.Execute(&buf, seriesData{Index: index}); err != nil {
+			return nil, errors.Wrapf(err, "execute template of label %s", l.name)
+		}
+
+		lset[j] = prompb.Label{Name: l.name, Value: buf.String()}
+	}
+
+	return lset, nil
+}
+
+// selector returns a series selector matching all series of the given label sets.
+func selector(sets [][]prompb.Label) string {
+	ms := matchers(sets)
+
+	strs := make([]string, len(ms))
+	for i, m := range ms {
+		op := "="
+		if m.Type == prompb.LabelMatcher_RE {
+			op = "=~"
+		}
+
+		strs[i] = fmt.Sprintf(`%s%s%q`, m.Name, op, m.Value)
+	}
+
+	return fmt.Sprintf("{%s}", strings.Join(strs, ","))
+}
+
+// matchers returns the label matchers selecting all series of the given label sets.
+// Labels with the same value across all sets are matched exactly,
+// the others are matched by a regular expression of all their values.
+func matchers(sets [][]prompb.Label) []prompb.LabelMatcher {
+	values := map[string]map[string]struct{}{}
+	names := []string{}
+
+	for _, lset := range sets {
+		for _, l := range lset {
+			if _, ok := values[l.Name]; !ok {
+				values[l.Name] = map[string]struct{}{}
+				names = append(names, l.Name)
+			}
+
+			values[l.Name][l.Value] = struct{}{}
+		}
+	}
+
+	ms := make([]prompb.LabelMatcher, 0, len(names))
+
+	for _, n := range names {
+		if len(values[n]) == 1 {
+			for v := range values[n] {
+				ms = append(ms, prompb.LabelMatcher{Type: prompb.LabelMatcher_EQ, Name: n, Value: v})
+			}
+
+			continue
+		}
+
+		alts := make([]string, 0, len(values[n]))
+		for v := range values[n] {
+			alts = append(alts, regexp.QuoteMeta(v))
+		}
+
+		sort.Strings(alts)
+		ms = append(ms, prompb.LabelMatcher{Type: prompb.LabelMatcher_RE, Name: n, Value: strings.Join(alts, "|")})
+	}
+
+	return ms
+}
+
+// seriesKey identifies a series by the values of the given label names.
+func seriesKey(names []string, value func(name string) string) string {
+	vs := make([]string, len(names))
+	for i, n := range names {
+		vs[i] = value(n)
+	}
+
+	return strings.Join(vs, "\xff")
+}
+
+// matchSeries maps every expected label set to the sample returned for it.
+// It returns the label sets that have no matching sample.
+func matchSeries(sets [][]prompb.Label, vec model.Vector) (map[int]*model.Sample, [][]prompb.Label) {
+	if len(sets) == 0 {
+		return nil, nil
+	}
+
+	names := make([]string, len(sets[0]))
+	for i, l := range sets[0] {
+		names[i] = l.Name
+	}
+
+	byKey := make(map[string]*model.Sample, len(vec))
+
+	for _, s := range vec {
+		m := s.Metric
+		byKey[seriesKey(names, func(n string) string { return string(m[model.LabelName(n)]) })] = s
+	}
+
+	var (
+		found   = make(map[int]*model.Sample, len(sets))
+		missing [][]prompb.Label
+	)
+
+	for i, lset := range sets {
+		lset := lset
+		key := seriesKey(names, func(n string) string {
+			for _, l := range lset {
+				if l.Name == n {
+					return l.Value
+				}
+			}
+
+			return ""
+		})
+
+		if s, ok := byKey[key]; ok {
+			found[i] = s
+			continue
+		}
+
+		missing = append(missing, lset)
+	}
+
+	return found, missing
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"github.com/gogo/protobuf/proto"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// writeRequest is a remote-write request that can hold native histograms, exemplars and metadata,
+// which the vendored prompb package does not support yet.
+// They are encoded by hand and appended to the encoded series and request they belong to.
+type writeRequest struct {
+	Timeseries []prompb.TimeSeries
+	// Histograms holds the native histograms of the series with the same index.
+	Histograms map[int][]nativeHistogram
+	// Exemplars holds the exemplars of the series with the same index.
+	Exemplars map[int][]exemplar
+	Metadata  []metricMetadata
+}
+
+// Marshal encodes the request as a prometheus.WriteRequest:
+//
+//	message WriteRequest { repeated TimeSeries timeseries = 1; repeated MetricMetadata metadata = 3; }
+//	message TimeSeries {
+//	  repeated Label labels = 1; repeated Sample samples = 2; repeated Exemplar exemplars = 3; repeated Histogram histograms = 4;
+//	}
+func (r *writeRequest) Marshal() ([]byte, error) {
+	b := proto.NewBuffer(nil)
+
+	for i := range r.Timeseries {
+		ts, err := r.Timeseries[i].Marshal()
+		if err != nil {
+			return nil, err
+		}
+
+		// Fields of a message can be appended to its encoding.
+		extra := proto.NewBuffer(ts)
+		for _, e := range r.Exemplars[i] {
+			if err := encodeFields(extra, messageField(3, e.fields()...)); err != nil {
+				return nil, err
+			}
+		}
+
+		for _, h := range r.Histograms[i] {
+			if err := encodeFields(extra, messageField(4, h.fields()...)); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := encodeFields(b, bytesField(1, extra.Bytes())); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, m := range r.Metadata {
+		if err := encodeFields(b, messageField(3, m.fields()...)); err != nil {
+			return nil, err
+		}
+	}
+
+	return b.Bytes(), nil
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/prometheus/prometheus/prompb"
+)
+
+// The messages of prometheus.WriteRequest used to decode the output of Marshal,
+// including the fields the vendored prompb package does not support yet.
+type rw1Request struct {
+	Timeseries []*rw1TimeSeries `protobuf:"bytes,1,rep,name=timeseries,proto3"`
+	Metadata   []*rw1Metadata   `protobuf:"bytes,3,rep,name=metadata,proto3"`
+}
+
+func (m *rw1Request) Reset()         { *m = rw1Request{} }
+func (m *rw1Request) String() string { return proto.CompactTextString(m) }
+func (*rw1Request) ProtoMessage()    {}
+
+type rw1TimeSeries struct {
+	Labels     []*prompb.Label  `protobuf:"bytes,1,rep,name=labels,proto3"`
+	Samples    []*prompb.Sample `protobuf:"bytes,2,rep,name=samples,proto3"`
+	Exemplars  []*rw1Exemplar   `protobuf:"bytes,3,rep,name=exemplars,proto3"`
+	Histograms []*rw1Histogram  `protobuf:"bytes,4,rep,name=histograms,proto3"`
+}
+
+func (m *rw1TimeSeries) Reset()         { *m = rw1TimeSeries{} }
+func (m *rw1TimeSeries) String() string { return proto.CompactTextString(m) }
+func (*rw1TimeSeries) ProtoMessage()    {}
+
+type rw1Exemplar struct {
+	Labels    []*prompb.Label `protobuf:"bytes,1,rep,name=labels,proto3"`
+	Value     float64         `protobuf:"fixed64,2,opt,name=value,proto3"`
+	Timestamp int64           `protobuf:"varint,3,opt,name=timestamp,proto3"`
+}
+
+func (m *rw1Exemplar) Reset()         { *m = rw1Exemplar{} }
+func (m *rw1Exemplar) String() string { return proto.CompactTextString(m) }
+func (*rw1Exemplar) ProtoMessage()    {}
+
+type rw1Metadata struct {
+	Type             int32  `protobuf:"varint,1,opt,name=type,proto3"`
+	MetricFamilyName string `protobuf:"bytes,2,opt,name=metric_family_name,proto3"`
+	Help             string `protobuf:"bytes,4,opt,name=help,proto3"`
+	Unit             string `protobuf:"bytes,5,opt,name=unit,proto3"`
+}
+
+func (m *rw1Metadata) Reset()         { *m = rw1Metadata{} }
+func (m *rw1Metadata) String() string { return proto.CompactTextString(m) }
+func (*rw1Metadata) ProtoMessage()    {}
+
+// rw1Histogram is tagged like prometheus.Histogram, limited to the integer counts of positive buckets.
+type rw1Histogram struct {
+	CountInt       uint64           `protobuf:"varint,1,opt,name=count_int,proto3"`
+	Sum            float64          `protobuf:"fixed64,3,opt,name=sum,proto3"`
+	Schema         int32            `protobuf:"zigzag32,4,opt,name=schema,proto3"`
+	PositiveSpans  []*rw1BucketSpan `protobuf:"bytes,11,rep,name=positive_spans,proto3"`
+	PositiveDeltas []int64          `protobuf:"zigzag64,12,rep,packed,name=positive_deltas,proto3"`
+	Timestamp      int64            `protobuf:"varint,15,opt,name=timestamp,proto3"`
+}
+
+func (m *rw1Histogram) Reset()         { *m = rw1Histogram{} }
+func (m *rw1Histogram) String() string { return proto.CompactTextString(m) }
+func (*rw1Histogram) ProtoMessage()    {}
+
+type rw1BucketSpan struct {
+	Offset int32  `protobuf:"zigzag32,1,opt,name=offset,proto3"`
+	Length uint32 `protobuf:"varint,2,opt,name=length,proto3"`
+}
+
+func (m *rw1BucketSpan) Reset()         { *m = rw1BucketSpan{} }
+func (m *rw1BucketSpan) String() string { return proto.CompactTextString(m) }
+func (*rw1BucketSpan) ProtoMessage()    {}
+
+// labels returns the decoded labels as a label set.
+func labels(ls []*prompb.Label) []prompb.Label {
+	lset := make([]prompb.Label, len(ls))
+	for i, l := range ls {
+		lset[i] = *l
+	}
+
+	return lset
+}
+
+func unmarshalRW1(t *testing.T, wreq *writeRequest) rw1Request {
+	t.Helper()
+
+	b, err := wreq.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var req rw1Request
+	if err := proto.Unmarshal(b, &req); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(req.Timeseries) != len(wreq.Timeseries) {
+		t.Fatalf("expected %d series, got %d", len(wreq.Timeseries), len(req.Timeseries))
+	}
+
+	for i, ts := range req.Timeseries {
+		if lset := labels(ts.Labels); !reflect.DeepEqual(lset, wreq.Timeseries[i].Labels) {
+			t.Errorf("series %d: expected labels %v, got %v", i, wreq.Timeseries[i].Labels, lset)
+		}
+	}
+
+	return req
+}
+
+func TestMarshalNativeHistograms(t *testing.T) {
+	wreq := &writeRequest{Histograms: map[int][]nativeHistogram{}}
+
+	appendTyped(wreq, seriesTypeNativeHistogram, []prompb.Label{{Name: "__name__", Value: "up"}}, 1234, 5678)
+
+	// Negative schemas and offsets check the zigzag encoding.
+	wreq.Timeseries = append(wreq.Timeseries, prompb.TimeSeries{Labels: []prompb.Label{{Name: "__name__", Value: "down"}}})
+	wreq.Histograms[1] = []nativeHistogram{{
+		Count:          3,
+		Sum:            -1.5,
+		Schema:         -2,
+		PositiveSpans:  []bucketSpan{{Offset: -3, Length: 1}, {Offset: 2, Length: 2}},
+		PositiveDeltas: []int64{1, -1, 2},
+		Timestamp:      99,
+	}}
+
+	req := unmarshalRW1(t, wreq)
+
+	for i, ts := range req.Timeseries {
+		if len(ts.Samples) != 0 {
+			t.Errorf("series %d: expected no float samples, got %v", i, ts.Samples)
+		}
+
+		if len(ts.Histograms) != 1 {
+			t.Fatalf("series %d: expected 1 histogram, got %d", i, len(ts.Histograms))
+		}
+
+		h, e := ts.Histograms[0], wreq.Histograms[i][0]
+
+		spans := make([]bucketSpan, len(h.PositiveSpans))
+		for j, s := range h.PositiveSpans {
+			spans[j] = bucketSpan{Offset: s.Offset, Length: s.Length}
+		}
+
+		decoded := nativeHistogram{
+			Count:          h.CountInt,
+			Sum:            h.Sum,
+			Schema:         h.Schema,
+			PositiveSpans:  spans,
+			PositiveDeltas: h.PositiveDeltas,
+			Timestamp:      h.Timestamp,
+		}
+
+		if !reflect.DeepEqual(decoded, e) {
+			t.Errorf("series %d: expected histogram %+v, got %+v", i, e, decoded)
+		}
+	}
+}
+
+func TestMarshalExemplarsAndMetadata(t *testing.T) {
+	for _, tc := range []struct {
+		typ string
+		// exemplarSeries is the metric name of the series expected to carry an exemplar.
+		exemplarSeries string
+		// exemplarValue is the value of the exemplars, or 0 if it is the value of the series.
+		exemplarValue float64
+		metadata      []rw1Metadata
+	}{
+		{
+			typ:            seriesTypeGauge,
+			exemplarSeries: "up",
+			metadata:       []rw1Metadata{{Type: 2, MetricFamilyName: "up", Help: "Heartbeat.", Unit: "milliseconds"}},
+		},
+		{
+			typ:            seriesTypeHistogram,
+			exemplarSeries: "up_bucket",
+			exemplarValue:  observationsMedian,
+			metadata:       []rw1Metadata{{Type: 3, MetricFamilyName: "up", Help: "Heartbeat.", Unit: "milliseconds"}},
+		},
+	} {
+		t.Run(tc.typ, func(t *testing.T) {
+			w, err := newWorkload([]prompb.Label{
+				{Name: "__name__", Value: "up"},
+				{Name: "instance", Value: "up-{{.Index}}"},
+			}, 2, tc.typ)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			w.exemplars = true
+			w.metadata = &metadataConfig{Enabled: true, Help: "Heartbeat.", Unit: "milliseconds"}
+
+			sets, err := w.labelSets()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			wreq := generate(sets, w)
+			req := unmarshalRW1(t, wreq)
+
+			var exemplars int
+
+			for i, ts := range req.Timeseries {
+				if len(ts.Exemplars) == 0 {
+					continue
+				}
+
+				exemplars++
+
+				lset := labels(ts.Labels)
+
+				if metricName(lset) != tc.exemplarSeries {
+					t.Fatalf("series %s: expected no exemplars, got %v", selector([][]prompb.Label{lset}), ts.Exemplars)
+				}
+
+				if len(ts.Exemplars) != 1 {
+					t.Fatalf("series %s: expected 1 exemplar, got %v", selector([][]prompb.Label{lset}), ts.Exemplars)
+				}
+
+				e := ts.Exemplars[0]
+
+				value := tc.exemplarValue
+				if value == 0 {
+					value = ts.Samples[0].Value
+				}
+
+				if e.Value != value || e.Timestamp != ts.Samples[0].Timestamp {
+					t.Errorf("series %s: expected exemplar %v at %d, got %v at %d",
+						selector([][]prompb.Label{lset}), value, ts.Samples[0].Timestamp, e.Value, e.Timestamp)
+				}
+
+				el := labels(e.Labels)
+				if len(el) != 1 || el[0].Name != exemplarTraceIDLabel || !traceIDPattern.MatchString(el[0].Value) {
+					t.Errorf("series %s: expected a trace ID label, got %v", selector([][]prompb.Label{lset}), el)
+				}
+
+				if !reflect.DeepEqual(el, wreq.Exemplars[i][0].Labels) {
+					t.Errorf("series %s: expected exemplar labels %v, got %v", selector([][]prompb.Label{lset}), wreq.Exemplars[i][0].Labels, el)
+				}
+			}
+
+			if exemplars != len(sets) {
+				t.Errorf("expected %d exemplars, got %d", len(sets), exemplars)
+			}
+
+			metadata := make([]rw1Metadata, len(req.Metadata))
+			for i, m := range req.Metadata {
+				metadata[i] = *m
+			}
+
+			if !reflect.DeepEqual(metadata, tc.metadata) {
+				t.Errorf("expected metadata %v, got %v", tc.metadata, metadata)
+			}
+		})
+	}
+}